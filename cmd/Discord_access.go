@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"log"
+	"slices"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/spf13/viper"
+)
+
+var discordRateLimiter = newRateLimiter()
+
+// discordRequester identifies who sent a message or interaction, and where
+type discordRequester struct {
+	userID    string
+	guildID   string
+	channelID string
+	roles     []string
+}
+
+func discordMessageRequester(m *discordgo.MessageCreate) discordRequester {
+	r := discordRequester{
+		userID:    m.Author.ID,
+		guildID:   m.GuildID,
+		channelID: m.ChannelID,
+	}
+	if m.Member != nil {
+		r.roles = m.Member.Roles
+	}
+	return r
+}
+
+func discordInteractionRequester(i *discordgo.InteractionCreate) discordRequester {
+	r := discordRequester{
+		guildID:   i.GuildID,
+		channelID: i.ChannelID,
+	}
+	if i.Member != nil {
+		r.userID = i.Member.User.ID
+		r.roles = i.Member.Roles
+	} else if i.User != nil {
+		r.userID = i.User.ID
+	}
+	return r
+}
+
+// discordAuthorize checks the access lists, rate limits and daily spending
+// quota for a request, returning a refusal message if it should not be served
+func discordAuthorize(r discordRequester) string {
+	if !discordAccessAllowed(r) {
+		log.Printf("🚫 Denied access to user %s in guild %s channel %s", r.userID, r.guildID, r.channelID)
+		metricDiscordRejections.inc("access")
+		return viper.GetString("discord_access_deniedMessage")
+	}
+
+	if limit := viper.GetFloat64("discord_quota_userDaily"); limit > 0 {
+		spent, _, err := ledger.spent("user:" + r.userID)
+		catchErr(err)
+		if spent >= limit {
+			log.Printf("💸 Daily quota used up by user %s in guild %s ($%.2f spent)", r.userID, r.guildID, spent)
+			metricDiscordRejections.inc("quota")
+			return viper.GetString("discord_quota_message")
+		}
+	}
+
+	if !discordRateLimiter.allow("user:"+r.userID, viper.GetFloat64("discord_rateLimit_userPerMinute"), viper.GetInt("discord_rateLimit_userBurst")) ||
+		(r.guildID != "" && !discordRateLimiter.allow("guild:"+r.guildID, viper.GetFloat64("discord_rateLimit_guildPerMinute"), viper.GetInt("discord_rateLimit_guildBurst"))) {
+		log.Printf("⏱️  Rate limited user %s in guild %s", r.userID, r.guildID)
+		metricDiscordRejections.inc("rate_limit")
+		return viper.GetString("discord_rateLimit_message")
+	}
+
+	return ""
+}
+
+// discordAccessAllowed applies the deny lists first, then the allow lists,
+// a request is allowed if it matches any allow list or no allow lists are set
+func discordAccessAllowed(r discordRequester) bool {
+	denied := slices.Contains(viper.GetStringSlice("discord_access_denyUsers"), r.userID) ||
+		slices.Contains(viper.GetStringSlice("discord_access_denyGuilds"), r.guildID) ||
+		slices.Contains(viper.GetStringSlice("discord_access_denyChannels"), r.channelID) ||
+		containsAny(viper.GetStringSlice("discord_access_denyRoles"), r.roles)
+	if denied {
+		return false
+	}
+
+	allowUsers := viper.GetStringSlice("discord_access_allowUsers")
+	allowGuilds := viper.GetStringSlice("discord_access_allowGuilds")
+	allowChannels := viper.GetStringSlice("discord_access_allowChannels")
+	allowRoles := viper.GetStringSlice("discord_access_allowRoles")
+	if len(allowUsers)+len(allowGuilds)+len(allowChannels)+len(allowRoles) == 0 {
+		return true
+	}
+
+	return slices.Contains(allowUsers, r.userID) ||
+		(r.guildID != "" && slices.Contains(allowGuilds, r.guildID)) ||
+		slices.Contains(allowChannels, r.channelID) ||
+		containsAny(allowRoles, r.roles)
+}
+
+// discordCheckBudget checks the spending budgets for a Discord command in the
+// requester's guild, returning a refusal message if a budget has been spent,
+// and a warning to show the requester when a budget is nearly spent
+func discordCheckBudget(command string, r discordRequester) (refusal, warning string) {
+	warning, err := checkBudget(command, r.guildID)
+	if err != nil {
+		log.Printf("💸 Refused %s in guild %s: %s", command, r.guildID, err)
+		metricDiscordRejections.inc("budget")
+		return viper.GetString("budget_message"), ""
+	}
+	if warning != "" {
+		log.Println("⚠️  Budget warning:", warning)
+		warning = "⚠️ " + warning
+	}
+	return "", warning
+}
+
+// discordIsAdmin reports whether an interaction was made by a server administrator
+func discordIsAdmin(i *discordgo.InteractionCreate) bool {
+	return i.GuildID != "" && i.Member != nil && i.Member.Permissions&discordgo.PermissionAdministrator != 0
+}
+
+// discordEphemeralResponse replies to an interaction with a message only the requester can see
+func discordEphemeralResponse(message string, s *discordgo.Session, i *discordgo.InteractionCreate) {
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: message,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	catchErr(err)
+}
+
+// containsAny reports whether any of values is in list
+func containsAny(list, values []string) bool {
+	for _, v := range values {
+		if slices.Contains(list, v) {
+			return true
+		}
+	}
+	return false
+}
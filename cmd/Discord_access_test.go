@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestDiscordAuthorizeQuota(t *testing.T) {
+	viper.Set("usage_ledgerFile", filepath.Join(t.TempDir(), "usage.jsonl"))
+	viper.Set("discord_quota_message", "quota")
+	previous := ledger
+	ledger = &usageLedger{sessions: map[string]usageRecord{}}
+	defer func() {
+		ledger = previous
+		for _, key := range []string{"usage_ledgerFile", "discord_quota_message", "discord_quota_userDaily"} {
+			viper.Set(key, nil)
+		}
+	}()
+	if _, _, err := ledger.spent(""); err != nil { // load the empty ledger so records are counted
+		t.Fatal(err)
+	}
+	ledger.add(usageRecord{Time: time.Now(), Command: "discord-chat", User: "spender", Cost: 0.5})
+	ledger.add(usageRecord{Time: time.Now(), Command: "discord-chat", User: "saver", Cost: 0.1})
+
+	tests := []struct {
+		name  string
+		user  string
+		limit float64
+		want  string
+	}{
+		{"unlimited", "spender", 0, ""},
+		{"under the quota", "saver", 0.25, ""},
+		{"quota used up", "spender", 0.25, "quota"},
+		{"nothing spent", "newcomer", 0.25, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("discord_quota_userDaily", tt.limit)
+			if got := discordAuthorize(discordRequester{userID: tt.user}); got != tt.want {
+				t.Errorf("discordAuthorize() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
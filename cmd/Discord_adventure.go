@@ -0,0 +1,519 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/openai/openai-go/v3"
+	"github.com/spf13/viper"
+)
+
+// Ways a campaign collects player actions
+const (
+	campaignModeTurns  = "turns"  // players act one at a time in join order
+	campaignModeWindow = "window" // actions submitted within a time window are resolved together
+)
+
+// discordCampaign is a multiplayer adventure played in a Discord thread
+type discordCampaign struct {
+	mu          sync.Mutex
+	threadID    string
+	guildID     string
+	hostID      string
+	mode        string
+	window      time.Duration
+	turnTimeout time.Duration         // turns not taken in time are skipped, zero waits forever
+	characters  map[string]*Character // by user ID
+	order       []string              // user IDs in join order
+	turn        int
+	skipped     int // turns skipped in a row, timeouts pause after a round of them
+	started     bool
+	context     *chatContext
+	pending     map[string]string // actions waiting for the window to close, by user ID
+	timer       *time.Timer       // closes the window, or skips the turn
+	requester   discordRequester  // charged for the next narration
+}
+
+var discordCampaigns = map[string]*discordCampaign{}
+var discordCampaignsMu sync.Mutex
+
+func discordAdventureCommand() *discordgo.ApplicationCommand {
+	minWindow := 10.0
+	return &discordgo.ApplicationCommand{
+		Name:        "ponder-adventure",
+		Description: "Play a multiplayer text adventure in a thread",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "start",
+				Description: "Start a new campaign in a thread",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "name",
+						Description: "Name of the campaign thread",
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "mode",
+						Description: "Take turns, or resolve everyone's actions together",
+						Choices: []*discordgo.ApplicationCommandOptionChoice{
+							{Name: "turns", Value: campaignModeTurns},
+							{Name: "window", Value: campaignModeWindow},
+						},
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Name:        "window",
+						Description: "Seconds to collect actions in window mode (default 60)",
+						MinValue:    &minWindow,
+						MaxValue:    600,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "join",
+				Description: "Create your character and join the campaign",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "name",
+						Description: "Your character's name",
+						Required:    true,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "description",
+						Description: "Appearance, personality, background, skills...",
+						Required:    true,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "begin",
+				Description: "Begin the adventure with the current party (host only)",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "status",
+				Description: "Show the party and whose turn it is",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "skip",
+				Description: "Skip the current player's turn (their own, the host's or admins')",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "end",
+				Description: "End the campaign (host or server admins)",
+			},
+		},
+	}
+}
+
+func discordPonderAdventure(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.GuildID == "" || i.Member == nil {
+		discordFollowUp("Adventures are only available in servers", s, i)
+		return
+	}
+
+	subcommand := i.ApplicationCommandData().Options[0]
+	if subcommand.Name == "start" {
+		discordAdventureStart(subcommand.Options, s, i)
+		return
+	}
+
+	campaign := discordCampaignFor(i.ChannelID)
+	if campaign == nil {
+		discordFollowUp("There's no adventure in this thread, start one with `/ponder-adventure start`", s, i)
+		return
+	}
+
+	userID := i.Member.User.ID
+	campaign.mu.Lock()
+	defer campaign.mu.Unlock()
+
+	switch subcommand.Name {
+	case "join":
+		var name, description string
+		for _, option := range subcommand.Options {
+			switch option.Name {
+			case "name":
+				name = option.StringValue()
+			case "description":
+				description = option.StringValue()
+			}
+		}
+		character := newCharacter(name, description)
+		if _, joined := campaign.characters[userID]; !joined { // Late joiners take their turn after everyone else
+			campaign.order = append(campaign.order, userID)
+		}
+		campaign.characters[userID] = &character
+		discordFollowUp(fmt.Sprintf("🗡️ <@%s> joins the party as **%s** 🛡️", userID, name), s, i)
+		if campaign.started {
+			campaign.context.pin(openai.SystemMessage(
+				fmt.Sprintf("A new adventurer joins the party: %s - %s", name, description)))
+		}
+
+	case "begin":
+		if userID != campaign.hostID {
+			discordFollowUp("Only the host can begin the adventure", s, i)
+			return
+		}
+		if campaign.started {
+			discordFollowUp("The adventure has already begun", s, i)
+			return
+		}
+		if len(campaign.order) == 0 {
+			discordFollowUp("Nobody has joined yet, create a character with `/ponder-adventure join`", s, i)
+			return
+		}
+
+		refusal, warning := discordCheckBudget("discord-adventure", discordInteractionRequester(i))
+		if refusal != "" {
+			discordFollowUp(refusal, s, i)
+			return
+		}
+
+		systemMessage, err := adventureSystemPrompt("THE PARTY'S STARTING CHARACTER STATS", campaign.party()...)
+		if err != nil {
+			discordFollowUp("❌ Error starting adventure: "+err.Error(), s, i)
+			return
+		}
+		campaign.context.reset(openai.SystemMessage(systemMessage))
+		campaign.started = true
+		campaign.requester = discordInteractionRequester(i)
+		discordFollowUp("⚔️ The adventure begins!", s, i)
+		if warning != "" {
+			discordEphemeralFollowUp(warning, s, i)
+		}
+
+		var names []string
+		for _, character := range campaign.party() {
+			names = append(names, character.Name)
+		}
+		campaign.narrate(s, "Our party is "+strings.Join(names, ", ")+". Start the adventure.")
+		if campaign.mode != campaignModeWindow {
+			campaign.startTurn(s, "")
+		}
+
+	case "status":
+		discordFollowUp(campaign.status(), s, i)
+
+	case "skip":
+		if !campaign.started || campaign.mode == campaignModeWindow {
+			discordFollowUp("Turns can only be skipped once a turn-based adventure has begun", s, i)
+			return
+		}
+		current := campaign.order[campaign.turn%len(campaign.order)]
+		if userID != current && userID != campaign.hostID && !discordIsAdmin(i) {
+			discordFollowUp("Only the current player, the host or a server admin can skip a turn", s, i)
+			return
+		}
+		discordFollowUp(fmt.Sprintf("⏭️ Skipped <@%s>'s turn", current), s, i)
+		campaign.skipTurn(s, "")
+
+	case "end":
+		if userID != campaign.hostID && !discordIsAdmin(i) {
+			discordFollowUp("Only the host or a server admin can end the adventure", s, i)
+			return
+		}
+		campaign.stopTimer()
+		discordCampaignsMu.Lock()
+		delete(discordCampaigns, campaign.threadID)
+		discordCampaignsMu.Unlock()
+		discordFollowUp("🏁 The adventure has ended. Thanks for playing!", s, i)
+	}
+}
+
+// discordAdventureStart opens a thread for a new campaign hosted by the user
+func discordAdventureStart(options []*discordgo.ApplicationCommandInteractionDataOption, s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if discordCampaignFor(i.ChannelID) != nil {
+		discordFollowUp("An adventure is already running in this thread", s, i)
+		return
+	}
+
+	name := "⚔️ Ponder Adventure"
+	mode := campaignModeTurns
+	window := 60 * time.Second
+	for _, option := range options {
+		switch option.Name {
+		case "name":
+			name = option.StringValue()
+		case "mode":
+			mode = option.StringValue()
+		case "window":
+			window = time.Duration(option.IntValue()) * time.Second
+		}
+	}
+
+	thread, err := s.ThreadStart(i.ChannelID, name, discordgo.ChannelTypeGuildPublicThread, 1440)
+	if err != nil {
+		log.Println("Error starting thread:", err)
+		discordFollowUp("❌ Error starting thread: "+err.Error(), s, i)
+		return
+	}
+
+	discordCampaignsMu.Lock()
+	discordCampaigns[thread.ID] = &discordCampaign{
+		threadID:    thread.ID,
+		guildID:     i.GuildID,
+		hostID:      i.Member.User.ID,
+		mode:        mode,
+		window:      window,
+		turnTimeout: viper.GetDuration("discord_adventure_turnTimeout"),
+		characters:  map[string]*Character{},
+		pending:     map[string]string{},
+		context:     newChatContext("discord-adventure", thread.ID, i.GuildID),
+	}
+	discordCampaignsMu.Unlock()
+
+	log.Println("⚔️  Started campaign:", thread.ID)
+	discordFollowUp(fmt.Sprintf("⚔️ A new adventure awaits in <#%s>!", thread.ID), s, i)
+	_, err = s.ChannelMessageSend(thread.ID, fmt.Sprintf(
+		"Welcome adventurers! Create your character with `/ponder-adventure join`, then <@%s> can `/ponder-adventure begin`.\n"+
+			"Once the adventure begins, describe what your character does in this thread.", i.Member.User.ID))
+	catchErr(err)
+}
+
+func discordCampaignFor(threadID string) *discordCampaign {
+	discordCampaignsMu.Lock()
+	defer discordCampaignsMu.Unlock()
+	return discordCampaigns[threadID]
+}
+
+// discordAdventureAction handles a message sent by a player in a campaign thread
+func discordAdventureAction(s *discordgo.Session, m *discordgo.MessageCreate, campaign *discordCampaign) {
+	campaign.mu.Lock()
+	defer campaign.mu.Unlock()
+
+	character := campaign.characters[m.Author.ID]
+	if !campaign.started || character == nil || strings.TrimSpace(m.Content) == "" {
+		return
+	}
+
+	requester := discordMessageRequester(m)
+	var warning string
+	refusal := discordAuthorize(requester)
+	if refusal == "" {
+		refusal, warning = discordCheckBudget("discord-adventure", requester)
+	}
+	if refusal != "" {
+		_, err := s.ChannelMessageSendReply(m.ChannelID, refusal, m.Reference())
+		catchErr(err)
+		return
+	}
+	if warning != "" {
+		_, err := s.ChannelMessageSendReply(m.ChannelID, warning, m.Reference())
+		catchErr(err)
+	}
+	campaign.requester = requester
+	metricDiscordMessages.inc()
+
+	switch campaign.mode {
+	case campaignModeWindow:
+		campaign.pending[m.Author.ID] = m.Content
+		err := s.MessageReactionAdd(m.ChannelID, m.ID, "✅")
+		catchErr(err)
+		if campaign.timer == nil && discordCtx.Err() == nil { // timers started during shutdown would never be stopped
+			_, err = s.ChannelMessageSend(campaign.threadID, fmt.Sprintf("⏳ Submit your actions, the narrator resolves them in %s", campaign.window))
+			catchErr(err)
+			discordInflight.Add(1)
+			campaign.timer = time.AfterFunc(campaign.window, func() {
+				defer discordInflight.Done()
+				defer discordRecover("adventure window")
+				campaign.mu.Lock()
+				defer campaign.mu.Unlock()
+				campaign.resolveWindow(s)
+			})
+		}
+
+	default:
+		current := campaign.order[campaign.turn%len(campaign.order)]
+		if m.Author.ID != current {
+			_, err := s.ChannelMessageSendReply(m.ChannelID, fmt.Sprintf("Hold on, it's <@%s>'s turn", current), m.Reference())
+			catchErr(err)
+			return
+		}
+		campaign.narrate(s, character.Name+": "+m.Content)
+		campaign.skipped = 0
+		campaign.turn = (campaign.turn + 1) % len(campaign.order)
+		campaign.startTurn(s, "")
+	}
+}
+
+// startTurn announces whose turn it is and skips it if they haven't acted
+// within the turn timeout, the caller must hold the lock
+func (campaign *discordCampaign) startTurn(s *discordgo.Session, note string) {
+	campaign.stopTimer()
+	current := campaign.order[campaign.turn%len(campaign.order)]
+	_, err := s.ChannelMessageSend(campaign.threadID, fmt.Sprintf("%s🎲 <@%s>, it's your turn", note, current))
+	catchErr(err)
+
+	// Stop skipping once nobody has acted for a whole round, the next action restarts the timeouts
+	if campaign.turnTimeout <= 0 || campaign.skipped >= len(campaign.order) || discordCtx.Err() != nil {
+		return
+	}
+	var timer *time.Timer
+	discordInflight.Add(1)
+	timer = time.AfterFunc(campaign.turnTimeout, func() {
+		defer discordInflight.Done()
+		defer discordRecover("adventure turn")
+		campaign.mu.Lock()
+		defer campaign.mu.Unlock()
+		if campaign.timer != timer || discordCampaignFor(campaign.threadID) == nil {
+			return // the turn was taken or skipped while waiting for the lock
+		}
+		campaign.timer = nil
+		campaign.skipTurn(s, fmt.Sprintf("⌛ <@%s> took too long, skipping their turn\n", current))
+	})
+	campaign.timer = timer
+}
+
+// skipTurn passes the turn to the next player without narrating, the caller must hold the lock
+func (campaign *discordCampaign) skipTurn(s *discordgo.Session, note string) {
+	campaign.skipped++
+	campaign.turn = (campaign.turn + 1) % len(campaign.order)
+	campaign.startTurn(s, note)
+}
+
+// stopTimer stops the window or turn timer without resolving the pending
+// actions or skipping the turn, the caller must hold the lock
+func (campaign *discordCampaign) stopTimer() {
+	if campaign.timer != nil && campaign.timer.Stop() {
+		discordInflight.Done()
+	}
+	campaign.timer = nil
+}
+
+// discordStopCampaignTimers stops the window and turn timers of all campaigns,
+// so shutdown doesn't wait for windows that haven't closed or turns not taken
+func discordStopCampaignTimers() {
+	discordCampaignsMu.Lock()
+	campaigns := make([]*discordCampaign, 0, len(discordCampaigns))
+	for _, campaign := range discordCampaigns {
+		campaigns = append(campaigns, campaign)
+	}
+	discordCampaignsMu.Unlock()
+	for _, campaign := range campaigns {
+		campaign.mu.Lock()
+		campaign.stopTimer()
+		campaign.mu.Unlock()
+	}
+}
+
+// resolveWindow narrates all actions submitted in the window together, the caller must hold the lock
+func (campaign *discordCampaign) resolveWindow(s *discordgo.Session) {
+	campaign.timer = nil
+	if discordCampaignFor(campaign.threadID) == nil || len(campaign.pending) == 0 {
+		return
+	}
+
+	var actions []string
+	for _, userID := range campaign.order {
+		name := campaign.characters[userID].Name
+		if action, ok := campaign.pending[userID]; ok {
+			actions = append(actions, name+": "+action)
+		} else {
+			actions = append(actions, name+" hesitates and does nothing.")
+		}
+	}
+	campaign.pending = map[string]string{}
+	campaign.narrate(s, "The party acts at the same time, resolve these actions together:\n"+strings.Join(actions, "\n"))
+}
+
+// narrate sends the prompt to the narrator and posts the response in the thread, the caller must hold the lock
+func (campaign *discordCampaign) narrate(s *discordgo.Session, prompt string) {
+	s.ChannelTyping(campaign.threadID)
+	campaign.context.add(openai.UserMessage(prompt))
+	model := viper.GetString("openAI_chat_model")
+	messages := campaign.context.prepare(discordRequestCtx, model)
+
+	ctx, cancel := openaiContext(discordRequestCtx)
+	defer cancel()
+	res, err := ai.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
+		Messages: messages,
+		Model:    model,
+	})
+	if err != nil {
+		log.Println("Error narrating adventure:", err)
+		_, err = s.ChannelMessageSend(campaign.threadID, "❌ The narrator lost their train of thought: "+err.Error())
+		catchErr(err)
+		return
+	}
+	recordChatUsage("discord-adventure", campaign.threadID, campaign.guildID, campaign.requester.userID, res)
+	campaign.context.calibrate(messages, res.Usage.PromptTokens)
+
+	narration := res.Choices[0].Message.Content
+	campaign.context.add(openai.AssistantMessage(narration))
+	for _, chunk := range discordSplitMessage(narration) {
+		_, err = s.ChannelMessageSend(campaign.threadID, chunk)
+		catchErr(err)
+	}
+}
+
+// party returns the characters in join order
+func (campaign *discordCampaign) party() []Character {
+	var party []Character
+	for _, userID := range campaign.order {
+		party = append(party, *campaign.characters[userID])
+	}
+	return party
+}
+
+// status describes the party and the game state
+func (campaign *discordCampaign) status() string {
+	var b strings.Builder
+	b.WriteString("⚔️ **Party**\n")
+	for _, userID := range campaign.order {
+		c := campaign.characters[userID]
+		fmt.Fprintf(&b, "**%s** (<@%s>) - Level %d, HP %.0f, MP %.0f\n", c.Name, userID, c.Level, c.HP, c.MP)
+	}
+	if len(campaign.order) == 0 {
+		b.WriteString("_nobody has joined yet_\n")
+	}
+
+	switch {
+	case !campaign.started:
+		fmt.Fprintf(&b, "\nWaiting for <@%s> to begin the adventure", campaign.hostID)
+	case campaign.mode == campaignModeWindow:
+		var waiting []string
+		for _, userID := range campaign.order {
+			if _, ok := campaign.pending[userID]; !ok {
+				waiting = append(waiting, campaign.characters[userID].Name)
+			}
+		}
+		fmt.Fprintf(&b, "\nActions are resolved together every %s", campaign.window)
+		if len(waiting) > 0 && len(campaign.pending) > 0 {
+			fmt.Fprintf(&b, ", waiting on: %s", strings.Join(waiting, ", "))
+		}
+	default:
+		fmt.Fprintf(&b, "\nIt's <@%s>'s turn", campaign.order[campaign.turn%len(campaign.order)])
+	}
+	return b.String()
+}
+
+// discordSplitMessage splits text into chunks that fit in a Discord message,
+// breaking on newlines where possible
+func discordSplitMessage(text string) []string {
+	const limit = 2000
+	var chunks []string
+	for utf8.RuneCountInString(text) > limit {
+		head := string([]rune(text)[:limit])
+		cut := strings.LastIndex(head, "\n")
+		if cut <= 0 {
+			cut = len(head)
+		}
+		chunks = append(chunks, head[:cut])
+		text = strings.TrimLeft(text[cut:], "\n")
+	}
+	return append(chunks, text)
+}
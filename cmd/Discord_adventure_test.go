@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestDiscordSplitMessage(t *testing.T) {
+	line := strings.Repeat("x", 999) + "\n" // 1000 runes
+	tests := []struct {
+		name string
+		text string
+		want []int // rune count of each chunk
+	}{
+		{"empty", "", []int{0}},
+		{"short", "hello", []int{5}},
+		{"exactly the limit", strings.Repeat("a", 2000), []int{2000}},
+		{"no newlines", strings.Repeat("a", 4500), []int{2000, 2000, 500}},
+		{"breaks on newlines", strings.Repeat(line, 3), []int{1999, 1000}},
+		{"newline at the start only", "\n" + strings.Repeat("a", 2500), []int{2000, 501}},
+		{"multibyte", strings.Repeat("é", 2001), []int{2000, 1}},
+		{"emoji", strings.Repeat("🎲", 4001), []int{2000, 2000, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := discordSplitMessage(tt.text)
+			var got []int
+			for _, chunk := range chunks {
+				if !utf8.ValidString(chunk) {
+					t.Errorf("chunk %q isn't valid UTF-8", chunk)
+				}
+				got = append(got, utf8.RuneCountInString(chunk))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("discordSplitMessage() chunk lengths = %v, want %v", got, tt.want)
+			}
+			if joined, text := strings.ReplaceAll(strings.Join(chunks, ""), "\n", ""), strings.ReplaceAll(tt.text, "\n", ""); joined != text {
+				t.Error("discordSplitMessage() lost text")
+			}
+		})
+	}
+}
+
+func TestCampaignTurnTimeout(t *testing.T) {
+	var mu sync.Mutex
+	var sent []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message discordgo.MessageSend
+		catchErr(json.NewDecoder(r.Body).Decode(&message))
+		mu.Lock()
+		sent = append(sent, message.Content)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"1"}`)
+	}))
+	defer srv.Close()
+	previous := discordgo.EndpointChannels
+	discordgo.EndpointChannels = srv.URL + "/channels/"
+	defer func() { discordgo.EndpointChannels = previous }()
+	s, err := discordgo.New("Bot test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		players     []string
+		timeout     time.Duration
+		wantTurn    int
+		wantSkipped int
+	}{
+		{"no timeout", []string{"a", "b"}, 0, 0, 0},
+		{"pauses after a round", []string{"a", "b"}, 5 * time.Millisecond, 0, 2},
+		{"single player", []string{"a"}, 5 * time.Millisecond, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			sent = nil
+			mu.Unlock()
+			campaign := &discordCampaign{threadID: "thread", order: tt.players, turnTimeout: tt.timeout}
+			discordCampaignsMu.Lock()
+			discordCampaigns[campaign.threadID] = campaign
+			discordCampaignsMu.Unlock()
+			defer func() {
+				discordCampaignsMu.Lock()
+				delete(discordCampaigns, campaign.threadID)
+				discordCampaignsMu.Unlock()
+			}()
+
+			campaign.mu.Lock()
+			campaign.startTurn(s, "")
+			campaign.mu.Unlock()
+			discordInflight.Wait() // the turn timers are in flight until the adventure pauses
+
+			campaign.mu.Lock()
+			defer campaign.mu.Unlock()
+			if campaign.turn != tt.wantTurn || campaign.skipped != tt.wantSkipped {
+				t.Errorf("turn, skipped = %d, %d, want %d, %d", campaign.turn, campaign.skipped, tt.wantTurn, tt.wantSkipped)
+			}
+			if campaign.timer != nil {
+				t.Error("the turn timer is still running")
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(sent) != tt.wantSkipped+1 {
+				t.Errorf("sent %d messages, want %d: %q", len(sent), tt.wantSkipped+1, sent)
+			}
+		})
+	}
+}
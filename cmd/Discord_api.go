@@ -2,22 +2,50 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
+	"runtime/debug"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/fsnotify/fsnotify"
 	"github.com/openai/openai-go/v3"
 	"github.com/spf13/viper"
 )
 
 var discord *discordgo.Session
 
-func initDiscord() {
+// discordCtx is cancelled when the bot starts shutting down, after which new
+// events are ignored, discordRequestCtx is cancelled once in-flight requests
+// have had discord_shutdownTimeout to finish
+var discordCtx, discordRequestCtx = context.Background(), context.Background()
+
+// Tracks event handlers that are still running
+var discordInflight sync.WaitGroup
+
+// Cancels the requests answering messages, by message ID, if the message is deleted
+var discordPending = struct {
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+}{cancels: map[string]context.CancelFunc{}}
+
+// Serves health checks and metrics when an HTTP address is configured
+var discordHTTPServer *http.Server
+
+func initDiscord(ctx context.Context) error {
+	discordCtx = ctx
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	discordRequestCtx = requestCtx
+
 	var err error
 	discord, err = discordgo.New("Bot " + discordAPIKey)
-	catchErr(err)
+	if err != nil {
+		return err
+	}
 
 	discord.Client = &http.Client{
 		Timeout: time.Second * 10,
@@ -25,16 +53,92 @@ func initDiscord() {
 
 	// Open a websocket connection to Discord
 	err = discord.Open()
+	if err != nil {
+		return err
+	}
+
+	// Reload access lists, rate limits and quotas when the config file changes
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		reloadPrices()
+		log.Println("🔄 Reloaded Config:", e.Name)
+	})
+	viper.WatchConfig()
+
+	err = discordGuildSettings.load()
 	catchErr(err)
-	defer discord.Close()
 
 	setStatusOnline()
 	registerHandlers()
 	registerCommands()
 	deregisterCommands()
 
+	if httpAddress == "" {
+		httpAddress = viper.GetString("discord_httpAddress")
+	}
+	if httpAddress != "" {
+		discordHTTPServer = startHTTPServer(httpAddress)
+	}
+
 	log.Println("🤖 Ponder Discord Bot is Running...")
-	select {} // Block forever to prevent the program from terminating.
+	<-ctx.Done() // Block until SIGTERM or Ctrl+C
+
+	log.Println("🛑 Shutting Down...")
+	discordShutdown(cancelRequests)
+	return nil
+}
+
+// discordShutdown waits for in-flight handlers to finish, cancelling their
+// requests if they take longer than discord_shutdownTimeout, then closes the gateway
+func discordShutdown(cancelRequests context.CancelFunc) {
+	drained := make(chan struct{})
+	go func() {
+		discordStopCampaignTimers() // a narration holding a campaign's lock is cancelled on timeout
+		discordInflight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		log.Println("✅ Finished In-Flight Requests")
+	case <-time.After(viper.GetDuration("discord_shutdownTimeout")):
+		log.Println("⌛ Timed Out Waiting for In-Flight Requests, Cancelling...")
+		cancelRequests()
+		<-drained
+	}
+
+	if err := discord.Close(); err != nil {
+		log.Println("Error closing Discord session:", err)
+	}
+	log.Println("👋 Disconnected from Discord")
+
+	if discordHTTPServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := discordHTTPServer.Shutdown(ctx); err != nil {
+			log.Println("Error stopping HTTP server:", err)
+		}
+	}
+}
+
+// discordHandler wraps an event handler so that it is ignored during shutdown,
+// tracked as in-flight, and recovers from panics instead of crashing the bot
+func discordHandler[T any](name string, handler func(*discordgo.Session, T)) func(*discordgo.Session, T) {
+	return func(s *discordgo.Session, event T) {
+		if discordCtx.Err() != nil {
+			return
+		}
+		discordInflight.Add(1)
+		defer discordInflight.Done()
+		defer discordRecover(name)
+		handler(s, event)
+	}
+}
+
+// discordRecover logs a panic in a handler or goroutine, use with defer
+func discordRecover(name string) {
+	if r := recover(); r != nil {
+		log.Printf("💥 Recovered from panic in %s: %v\n%s", name, r, debug.Stack())
+	}
 }
 
 func setStatusOnline() {
@@ -55,8 +159,9 @@ func setStatusOnline() {
 
 func registerHandlers() {
 	log.Println("💾 Registering Handlers...")
-	discord.AddHandler(handleCommands)
-	discord.AddHandler(handleMessages)
+	discord.AddHandler(discordHandler("commands", handleCommands))
+	discord.AddHandler(discordHandler("messages", handleMessages))
+	discord.AddHandler(discordHandler("message delete", handleMessageDelete))
 }
 
 func deregisterCommands() {
@@ -79,7 +184,7 @@ func registerCommands() {
 	commands := []*discordgo.ApplicationCommand{
 		{
 			Name:        "ponder-image",
-			Description: "Use DALL-E 3 to generate an Image",
+			Description: "Generate an Image from a prompt",
 			Options: []*discordgo.ApplicationCommandOption{
 				{
 					Type:        discordgo.ApplicationCommandOptionString,
@@ -89,6 +194,8 @@ func registerCommands() {
 				},
 			},
 		},
+		discordConfigCommand(),
+		discordAdventureCommand(),
 	}
 
 	for _, command := range commands {
@@ -99,27 +206,83 @@ func registerCommands() {
 }
 
 func handleCommands(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	discordInitialResponse("Pondering...", s, i)
-	switch i.ApplicationCommandData().Name {
-	case "ponder-image":
-		discordPonderImage(s, i)
-	default: // Handle unknown slash commands
-		log.Printf("Unknown Ponder Command: %s", i.ApplicationCommandData().Name)
+	// Always available to admins, so it can't lock itself out, and doesn't use up their rate limit
+	if i.Type == discordgo.InteractionApplicationCommand && i.ApplicationCommandData().Name == "ponder-config" {
+		metricDiscordCommands.inc("ponder-config")
+		discordPonderConfig(s, i)
+		return
+	}
+
+	if refusal := discordAuthorize(discordInteractionRequester(i)); refusal != "" {
+		discordEphemeralResponse(refusal, s, i)
+		return
+	}
+
+	settings := discordGuildSettings.resolve(i.GuildID, i.ChannelID)
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		name := i.ApplicationCommandData().Name
+		metricDiscordCommands.inc(name)
+		if !settings.commandEnabled(name) {
+			discordEphemeralResponse("🚫 /"+name+" is disabled here.", s, i)
+			return
+		}
+		discordInitialResponse("Pondering...", s, i)
+		switch name {
+		case "ponder-image":
+			discordPonderImage(s, i)
+		case "ponder-adventure":
+			discordPonderAdventure(s, i)
+		default: // Handle unknown slash commands
+			log.Printf("Unknown Ponder Command: %s", i.ApplicationCommandData().Name)
+		}
+	case discordgo.InteractionMessageComponent:
+		metricDiscordCommands.inc(i.MessageComponentData().CustomID)
+		if !settings.commandEnabled("ponder-image") {
+			discordEphemeralResponse("🚫 /ponder-image is disabled here.", s, i)
+			return
+		}
+		discordInitialResponse("Pondering...", s, i)
+		switch i.MessageComponentData().CustomID {
+		case discordImageRegenerateID:
+			discordImageRegenerate(s, i)
+		case discordImageVariationID:
+			discordImageVariation(s, i)
+		default: // Handle unknown buttons
+			log.Printf("Unknown Ponder Component: %s", i.MessageComponentData().CustomID)
+		}
 	}
 }
 
 func handleMessages(s *discordgo.Session, m *discordgo.MessageCreate) {
 
-	// Ignore all messages created by the bot itself
-	if m.Author.ID == discord.State.User.ID {
+	// Ignore all messages created by the bot itself, and by other bots so
+	// channels in all mode don't turn into bots replying to each other
+	if m.Author.ID == discord.State.User.ID || m.Author.Bot {
 		return
 	}
 
 	// channelName := discordGetChannelName(m.ChannelID)
 
-	// Respond to messages in the #ponder channel
+	// Messages in adventure threads are player actions
+	if campaign := discordCampaignFor(m.ChannelID); campaign != nil {
+		discordAdventureAction(s, m, campaign)
+		return
+	}
+
+	// Respond to direct messages
 	if m.GuildID == "" {
-		discordOpenAIResponse(s, m)
+		if viper.GetBool("discord_bot_directMessages") {
+			discordAuthorizedResponse(s, m)
+		}
+		return
+	}
+
+	switch discordGuildSettings.resolve(m.GuildID, m.ChannelID).ResponseMode {
+	case discordModeOff:
+		return
+	case discordModeAll:
+		discordAuthorizedResponse(s, m)
 		return
 	}
 
@@ -127,66 +290,86 @@ func handleMessages(s *discordgo.Session, m *discordgo.MessageCreate) {
 	for _, user := range m.Mentions {
 		if user.ID == s.State.User.ID {
 			// Send a reply to the user who mentioned the bot.
-			discordOpenAIResponse(s, m)
+			discordAuthorizedResponse(s, m)
 			return
 		}
 	}
 
 }
 
+// discordAuthorizedResponse responds to a message if the author passes the access checks
+func discordAuthorizedResponse(s *discordgo.Session, m *discordgo.MessageCreate) {
+	requester := discordMessageRequester(m)
+	var warning string
+	refusal := discordAuthorize(requester)
+	if refusal == "" {
+		refusal, warning = discordCheckBudget("discord-chat", requester)
+	}
+	if refusal != "" {
+		_, err := s.ChannelMessageSendReply(m.ChannelID, refusal, m.Reference())
+		catchErr(err)
+		return
+	}
+	if warning != "" {
+		_, err := s.ChannelMessageSendReply(m.ChannelID, warning, m.Reference())
+		catchErr(err)
+	}
+	metricDiscordMessages.inc()
+	discordOpenAIResponse(s, m)
+}
+
 func discordOpenAIResponse(s *discordgo.Session, m *discordgo.MessageCreate) {
 	discord.ChannelTyping(m.ChannelID)
+	settings := discordGuildSettings.resolve(m.GuildID, m.ChannelID)
 	openaiMessages := []openai.ChatCompletionMessageParamUnion{
-		openai.SystemMessage(viper.GetString("discord_bot_systemMessage")),
+		openai.SystemMessage(settings.SystemMessage),
 	}
 
-	discordMessages, err := discord.ChannelMessages(m.ChannelID, viper.GetInt("discord_message_context_count"), "", "", "")
-	catchErr(err)
+	discordMessages, err := discord.ChannelMessages(m.ChannelID, settings.ContextCount, "", "", "")
+	if err != nil { // Answer without the conversation history
+		log.Println("Error fetching channel messages:", err)
+		discordMessages = []*discordgo.Message{m.Message}
+	}
 	discordMessages = discordReverseMessageOrder(discordMessages)
 
 	for _, message := range discordMessages {
 		if message.Author.ID == discord.State.User.ID {
 			openaiMessages = append(openaiMessages, openai.AssistantMessage(message.Content))
 		} else {
-			openaiMessages = append(openaiMessages, openai.UserMessage(message.Content))
+			openaiMessages = append(openaiMessages, userMessage(message.Content, discordImageURLs(message)))
 		}
 	}
 
-	// Send the messages to OpenAI
-	oaiResponse, err := ai.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
+	params := openai.ChatCompletionNewParams{
 		Messages: openaiMessages,
-		Model:    viper.GetString("openAI_chat_model"),
-	})
-	catchErr(err)
-	s.ChannelMessageSend(m.ChannelID, oaiResponse.Choices[0].Message.Content)
-}
-
-func discordPonderImage(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	channelID := i.ChannelID
-	discord.ChannelTyping(channelID)
-	commandData := i.ApplicationCommandData()
-
-	// Check if there are options and retrieve the prompt
-	if len(commandData.Options) > 0 {
-		promptOption := commandData.Options[0]
-		prompt := promptOption.StringValue()
-		discordFollowUp("Using DALL-E 3 to generate an image: "+prompt, s, i)
-		res, err := ai.Images.Generate(context.Background(), openai.ImageGenerateParams{
-			Prompt: prompt,
-			Model:  openai.ImageModel(viper.GetString("openAI_image_model")),
-			Size:   openai.ImageGenerateParamsSize(viper.GetString("openAI_image_size")),
-			N:      openai.Int(1),
-		})
-		if err != nil {
-			log.Println("Error generating image:", err)
-			discordFollowUp("❌ Error generating image: "+err.Error(), s, i)
+		Model:    settings.Model,
+	}
+	if hasImageContent(openaiMessages) && viper.GetString("openAI_chat_visionModel") != "" {
+		params.Model = viper.GetString("openAI_chat_visionModel")
+	}
+	if settings.Temperature != nil {
+		params.Temperature = openai.Float(*settings.Temperature)
+	}
+
+	// Send the messages to OpenAI
+	ctx, done := discordMessageContext(m.ID)
+	defer done()
+	oaiResponse, err := ai.Chat.Completions.New(ctx, params)
+	if errors.Is(err, context.Canceled) {
+		log.Println("🗑️  Cancelled response to deleted message:", m.ID)
+		return
+	} else if err != nil {
+		discordErrorReply("Error generating response", err, s, m)
+		return
+	}
+	recordChatUsage("discord-chat", m.ChannelID, m.GuildID, m.Author.ID, oaiResponse)
+	reply := oaiResponse.Choices[0].Message.Content
+	for _, chunk := range discordSplitMessage(reply) {
+		if _, err := s.ChannelMessageSend(m.ChannelID, chunk); err != nil {
+			log.Println("Error sending message:", err)
 			return
 		}
-		s.ChannelMessageSend(channelID, res.Data[0].URL)
-	} else {
-		discordFollowUp("Please Provide a Prompt for Image Generation", s, i)
 	}
-
 }
 
 func discordInitialResponse(content string, s *discordgo.Session, i *discordgo.InteractionCreate) {
@@ -209,6 +392,64 @@ func discordFollowUp(message string, s *discordgo.Session, i *discordgo.Interact
 	catchErr(err)
 }
 
+// discordEphemeralFollowUp follows up an interaction with a message only the requester can see
+func discordEphemeralFollowUp(message string, s *discordgo.Session, i *discordgo.InteractionCreate) {
+	followup := &discordgo.WebhookParams{
+		Content: message,
+		Flags:   discordgo.MessageFlagsEphemeral,
+	}
+	_, err := s.FollowupMessageCreate(i.Interaction, false, followup)
+	catchErr(err)
+}
+
+// discordErrorReply logs an error and lets the author of the message know something went wrong
+func discordErrorReply(action string, err error, s *discordgo.Session, m *discordgo.MessageCreate) {
+	log.Println(action+":", err)
+	reply := "❌ " + action + ": " + err.Error()
+	if errors.Is(err, context.DeadlineExceeded) {
+		reply = "⏱️ " + action + ": OpenAI took too long to respond, please try again"
+	}
+	if _, err := s.ChannelMessageSendReply(m.ChannelID, reply, m.Reference()); err != nil {
+		log.Println("Error sending message:", err)
+	}
+}
+
+// discordMessageContext returns a context for answering a message, bounded by
+// openAI_timeout and cancelled if the message is deleted, call done when finished
+func discordMessageContext(messageID string) (ctx context.Context, done func()) {
+	ctx, cancel := openaiContext(discordRequestCtx)
+	discordPending.mu.Lock()
+	discordPending.cancels[messageID] = cancel
+	discordPending.mu.Unlock()
+	return ctx, func() {
+		discordPending.mu.Lock()
+		delete(discordPending.cancels, messageID)
+		discordPending.mu.Unlock()
+		cancel()
+	}
+}
+
+// handleMessageDelete cancels the response to a message that was deleted
+func handleMessageDelete(s *discordgo.Session, m *discordgo.MessageDelete) {
+	discordPending.mu.Lock()
+	cancel := discordPending.cancels[m.ID]
+	discordPending.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// discordImageURLs returns the URLs of the image attachments of a message
+func discordImageURLs(message *discordgo.Message) []string {
+	var urls []string
+	for _, attachment := range message.Attachments {
+		if visionContentTypes[attachment.ContentType] {
+			urls = append(urls, attachment.URL)
+		}
+	}
+	return urls
+}
+
 // function to reverse the order of a slice
 func discordReverseMessageOrder(s []*discordgo.Message) []*discordgo.Message {
 	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
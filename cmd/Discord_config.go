@@ -0,0 +1,394 @@
+package cmd
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/spf13/viper"
+)
+
+// discordSettings are per-guild or per-channel overrides of the bot's global config
+type discordSettings struct {
+	Persona       string   `json:"persona,omitempty"` // from the personas config, other settings take precedence
+	SystemMessage string   `json:"systemMessage,omitempty"`
+	Model         string   `json:"model,omitempty"`
+	Temperature   *float64 `json:"temperature,omitempty"`
+	ContextCount  int      `json:"contextCount,omitempty"`
+	Commands      []string `json:"commands,omitempty"`     // enabled slash commands, nil or discordCommandsAll enables all
+	ResponseMode  string   `json:"responseMode,omitempty"` // mention, all or off
+}
+
+// Response modes for messages in guild channels
+const (
+	discordModeMention = "mention" // respond when @mentioned
+	discordModeAll     = "all"     // respond to every message
+	discordModeOff     = "off"     // only respond to slash commands
+)
+
+// discordCommandsAll enables all slash commands, overriding a guild's list for a channel
+const discordCommandsAll = "all"
+
+// Settings that can be changed with /ponder-config
+var discordSettingNames = []string{"persona", "system-message", "model", "temperature", "context-count", "commands", "response-mode"}
+
+// discordSettingsStore holds the guild and channel overrides, persisted as JSON
+type discordSettingsStore struct {
+	mu       sync.Mutex
+	Guilds   map[string]*discordSettings `json:"guilds"`
+	Channels map[string]*discordSettings `json:"channels"`
+}
+
+var discordGuildSettings = &discordSettingsStore{
+	Guilds:   map[string]*discordSettings{},
+	Channels: map[string]*discordSettings{},
+}
+
+func discordSettingsFile() string {
+	return expandHome(viper.GetString("discord_bot_settingsFile"))
+}
+
+// load reads the persisted overrides, a missing file leaves the store empty
+func (store *discordSettingsStore) load() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	data, err := os.ReadFile(discordSettingsFile())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, store); err != nil {
+		return err
+	}
+	if store.Guilds == nil {
+		store.Guilds = map[string]*discordSettings{}
+	}
+	if store.Channels == nil {
+		store.Channels = map[string]*discordSettings{}
+	}
+	return nil
+}
+
+// save writes the overrides to disk, the caller must hold the lock
+func (store *discordSettingsStore) save() error {
+	path := discordSettingsFile()
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// resolve merges the global config with the guild and channel overrides
+func (store *discordSettingsStore) resolve(guildID, channelID string) discordSettings {
+	settings := discordSettings{
+		SystemMessage: viper.GetString("discord_bot_systemMessage"),
+		Model:         viper.GetString("openAI_chat_model"),
+		ContextCount:  viper.GetInt("discord_message_context_count"),
+		ResponseMode:  viper.GetString("discord_bot_responseMode"),
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for _, override := range []*discordSettings{store.Guilds[guildID], store.Channels[channelID]} {
+		if override == nil {
+			continue
+		}
+		if override.Persona != "" {
+			if p, err := findPersona(override.Persona); err == nil {
+				settings.Persona = override.Persona
+				settings.SystemMessage = cmp.Or(p.SystemMessage, settings.SystemMessage)
+				settings.Model = cmp.Or(p.Model, settings.Model)
+				if p.Temperature != nil {
+					settings.Temperature = p.Temperature
+				}
+			} else {
+				log.Println("Error resolving persona:", err)
+			}
+		}
+		if override.SystemMessage != "" {
+			settings.SystemMessage = override.SystemMessage
+		}
+		if override.Model != "" {
+			settings.Model = override.Model
+		}
+		if override.Temperature != nil {
+			settings.Temperature = override.Temperature
+		}
+		if override.ContextCount > 0 {
+			settings.ContextCount = override.ContextCount
+		}
+		if override.Commands != nil {
+			settings.Commands = override.Commands
+		}
+		if override.ResponseMode != "" {
+			settings.ResponseMode = override.ResponseMode
+		}
+	}
+	return settings
+}
+
+// update applies fn to the guild or channel overrides and persists the result
+func (store *discordSettingsStore) update(guildID, channelID string, fn func(*discordSettings) error) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	scope, key := store.Guilds, guildID
+	if channelID != "" {
+		scope, key = store.Channels, channelID
+	}
+	settings := scope[key]
+	if settings == nil {
+		settings = &discordSettings{}
+	}
+	if err := fn(settings); err != nil {
+		return err
+	}
+	if settings.isEmpty() {
+		delete(scope, key)
+	} else {
+		scope[key] = settings
+	}
+	return store.save()
+}
+
+// isEmpty reports whether no settings are overridden
+func (settings discordSettings) isEmpty() bool {
+	return settings.Persona == "" && settings.SystemMessage == "" && settings.Model == "" && settings.Temperature == nil &&
+		settings.ContextCount == 0 && settings.Commands == nil && settings.ResponseMode == ""
+}
+
+// commandEnabled reports whether a slash command may be used with these settings
+func (settings discordSettings) commandEnabled(name string) bool {
+	return len(settings.Commands) == 0 || slices.Contains(settings.Commands, discordCommandsAll) || slices.Contains(settings.Commands, name)
+}
+
+// set changes a single setting from its /ponder-config string value
+func (settings *discordSettings) set(name, value string) error {
+	switch name {
+	case "persona":
+		if _, err := findPersona(value); err != nil {
+			return err
+		}
+		settings.Persona = strings.ToLower(value)
+	case "system-message":
+		settings.SystemMessage = value
+	case "model":
+		settings.Model = value
+	case "temperature":
+		temperature, err := strconv.ParseFloat(value, 64)
+		if err != nil || temperature < 0 || temperature > 2 {
+			return fmt.Errorf("temperature must be a number between 0 and 2")
+		}
+		settings.Temperature = &temperature
+	case "context-count":
+		count, err := strconv.Atoi(value)
+		if err != nil || count < 1 || count > 100 {
+			return fmt.Errorf("context-count must be a number between 1 and 100")
+		}
+		settings.ContextCount = count
+	case "commands":
+		settings.Commands = nil // "all" is kept as discordCommandsAll, so a channel can override the guild's list
+		for _, command := range strings.Split(value, ",") {
+			settings.Commands = append(settings.Commands, strings.TrimPrefix(strings.TrimSpace(command), "/"))
+		}
+	case "response-mode":
+		if !slices.Contains([]string{discordModeMention, discordModeAll, discordModeOff}, value) {
+			return fmt.Errorf("response-mode must be one of: mention, all, off")
+		}
+		settings.ResponseMode = value
+	default:
+		return fmt.Errorf("unknown setting: %s", name)
+	}
+	return nil
+}
+
+// reset clears a single setting, or all of them if name is empty
+func (settings *discordSettings) reset(name string) error {
+	switch name {
+	case "":
+		*settings = discordSettings{}
+	case "persona":
+		settings.Persona = ""
+	case "system-message":
+		settings.SystemMessage = ""
+	case "model":
+		settings.Model = ""
+	case "temperature":
+		settings.Temperature = nil
+	case "context-count":
+		settings.ContextCount = 0
+	case "commands":
+		settings.Commands = nil
+	case "response-mode":
+		settings.ResponseMode = ""
+	default:
+		return fmt.Errorf("unknown setting: %s", name)
+	}
+	return nil
+}
+
+// String formats the settings for display in Discord
+func (settings discordSettings) String() string {
+	var b strings.Builder
+	if settings.Persona != "" {
+		fmt.Fprintf(&b, "**persona:** %s\n", settings.Persona)
+	}
+	if settings.SystemMessage != "" {
+		fmt.Fprintf(&b, "**system-message:** %s\n", truncate(strings.TrimSpace(settings.SystemMessage), 500))
+	}
+	if settings.Model != "" {
+		fmt.Fprintf(&b, "**model:** %s\n", settings.Model)
+	}
+	if settings.Temperature != nil {
+		fmt.Fprintf(&b, "**temperature:** %g\n", *settings.Temperature)
+	}
+	if settings.ContextCount > 0 {
+		fmt.Fprintf(&b, "**context-count:** %d\n", settings.ContextCount)
+	}
+	if settings.Commands != nil {
+		fmt.Fprintf(&b, "**commands:** %s\n", strings.Join(settings.Commands, ", "))
+	}
+	if settings.ResponseMode != "" {
+		fmt.Fprintf(&b, "**response-mode:** %s\n", settings.ResponseMode)
+	}
+	if b.Len() == 0 {
+		return "_no overrides_\n"
+	}
+	return b.String()
+}
+
+// discordConfigCommand is the admin-only /ponder-config slash command
+func discordConfigCommand() *discordgo.ApplicationCommand {
+	adminPermission := int64(discordgo.PermissionAdministrator)
+	dmPermission := false
+
+	settingChoices := []*discordgo.ApplicationCommandOptionChoice{}
+	for _, name := range discordSettingNames {
+		settingChoices = append(settingChoices, &discordgo.ApplicationCommandOptionChoice{Name: name, Value: name})
+	}
+	channelOption := &discordgo.ApplicationCommandOption{
+		Type:         discordgo.ApplicationCommandOptionChannel,
+		Name:         "channel",
+		Description:  "Apply to a single channel instead of the whole server",
+		ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText},
+	}
+
+	return &discordgo.ApplicationCommand{
+		Name:                     "ponder-config",
+		Description:              "Configure Ponder for this server or a channel",
+		DefaultMemberPermissions: &adminPermission,
+		DMPermission:             &dmPermission,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "show",
+				Description: "Show the current settings",
+				Options:     []*discordgo.ApplicationCommandOption{channelOption},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "set",
+				Description: "Change a setting",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "setting",
+						Description: "Setting to change",
+						Required:    true,
+						Choices:     settingChoices,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "value",
+						Description: "New value, commands take a comma separated list or \"all\"",
+						Required:    true,
+					},
+					channelOption,
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "reset",
+				Description: "Reset a setting, or all settings, to the default",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "setting",
+						Description: "Setting to reset, all settings if omitted",
+						Choices:     settingChoices,
+					},
+					channelOption,
+				},
+			},
+		},
+	}
+}
+
+func discordPonderConfig(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if !discordIsAdmin(i) {
+		discordEphemeralResponse("🚫 Only server administrators can configure Ponder.", s, i)
+		return
+	}
+
+	subcommand := i.ApplicationCommandData().Options[0]
+	var setting, value, channelID string
+	for _, option := range subcommand.Options {
+		switch option.Name {
+		case "setting":
+			setting = option.StringValue()
+		case "value":
+			value = option.StringValue()
+		case "channel":
+			channelID = option.ChannelValue(nil).ID
+		}
+	}
+
+	var err error
+	switch subcommand.Name {
+	case "set":
+		err = discordGuildSettings.update(i.GuildID, channelID, func(settings *discordSettings) error {
+			return settings.set(setting, value)
+		})
+	case "reset":
+		err = discordGuildSettings.update(i.GuildID, channelID, func(settings *discordSettings) error {
+			return settings.reset(setting)
+		})
+	}
+	if err != nil {
+		log.Println("Error updating settings:", err)
+		discordEphemeralResponse("❌ "+err.Error(), s, i)
+		return
+	}
+
+	discordGuildSettings.mu.Lock()
+	guildSettings := discordSettings{}
+	if override := discordGuildSettings.Guilds[i.GuildID]; override != nil {
+		guildSettings = *override
+	}
+	channelSettings := discordSettings{}
+	if override := discordGuildSettings.Channels[channelID]; override != nil {
+		channelSettings = *override
+	}
+	discordGuildSettings.mu.Unlock()
+
+	content := "⚙️ **Server Settings**\n" + guildSettings.String()
+	if channelID != "" {
+		content += fmt.Sprintf("\n⚙️ **<#%s> Settings**\n", channelID) + channelSettings.String()
+	}
+	discordEphemeralResponse(truncate(content, 2000), s, i)
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import "testing"
+
+func TestDiscordCommandsOverride(t *testing.T) {
+	tests := []struct {
+		name           string
+		guild, channel string // commands setting, empty if not set
+		command        string
+		want           bool
+	}{
+		{"no overrides", "", "", "ponder-image", true},
+		{"guild list", "ponder-image", "", "ponder-image", true},
+		{"not in guild list", "ponder-image", "", "ponder-adventure", false},
+		{"slashes and spaces", " /ponder-image, /ponder-adventure", "", "ponder-adventure", true},
+		{"channel all overrides guild list", "ponder-image", "all", "ponder-adventure", true},
+		{"channel list overrides guild all", "all", "ponder-image", "ponder-adventure", false},
+		{"channel list overrides guild list", "ponder-image", "ponder-adventure", "ponder-adventure", true},
+		{"channel only", "", "ponder-image", "ponder-adventure", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &discordSettingsStore{Guilds: map[string]*discordSettings{}, Channels: map[string]*discordSettings{}}
+			for _, scope := range []struct {
+				overrides map[string]*discordSettings
+				id, value string
+			}{{store.Guilds, "guild", tt.guild}, {store.Channels, "channel", tt.channel}} {
+				if scope.value == "" {
+					continue
+				}
+				settings := &discordSettings{}
+				if err := settings.set("commands", scope.value); err != nil {
+					t.Fatal(err)
+				}
+				if settings.isEmpty() {
+					t.Fatalf("commands %q isn't kept as an override", scope.value)
+				}
+				scope.overrides[scope.id] = settings
+			}
+			if got := store.resolve("guild", "channel").commandEnabled(tt.command); got != tt.want {
+				t.Errorf("commandEnabled(%q) = %v, want %v", tt.command, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	_ "image/gif" // decoders for variationImage
+	_ "image/jpeg"
+	"image/png"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/openai/openai-go/v3"
+)
+
+// Custom IDs for the buttons attached to generated images
+const (
+	discordImageRegenerateID = "ponder-image-regenerate"
+	discordImageVariationID  = "ponder-image-variation"
+)
+
+func discordPonderImage(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	discord.ChannelTyping(i.ChannelID)
+	commandData := i.ApplicationCommandData()
+
+	// Check if there are options and retrieve the prompt
+	if len(commandData.Options) == 0 {
+		discordFollowUp("Please Provide a Prompt for Image Generation", s, i)
+		return
+	}
+	prompt := commandData.Options[0].StringValue()
+	discordImageGenerate(prompt, s, i)
+}
+
+// discordImageRegenerate generates a new image from the prompt stored in the
+// embed of the message whose "Regenerate" button was pressed
+func discordImageRegenerate(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Message == nil || len(i.Message.Embeds) == 0 || i.Message.Embeds[0].Description == "" {
+		discordFollowUp("❌ Unable to find the original prompt for this image", s, i)
+		return
+	}
+	discord.ChannelTyping(i.ChannelID)
+	discordImageGenerate(i.Message.Embeds[0].Description, s, i)
+}
+
+// discordImageVariation creates a variation of the image attached to the
+// message whose "Variations" button was pressed
+func discordImageVariation(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Message == nil || len(i.Message.Attachments) == 0 {
+		discordFollowUp("❌ Unable to find the original image", s, i)
+		return
+	}
+	discord.ChannelTyping(i.ChannelID)
+
+	var prompt string
+	if len(i.Message.Embeds) > 0 {
+		prompt = i.Message.Embeds[0].Description
+	}
+
+	refusal, warning := discordCheckBudget("discord-image", discordInteractionRequester(i))
+	if refusal != "" {
+		discordFollowUp(refusal, s, i)
+		return
+	}
+	if warning != "" {
+		discordEphemeralFollowUp(warning, s, i)
+	}
+
+	original, err := httpGetBytes(i.Message.Attachments[0].URL)
+	if err != nil {
+		log.Println("Error downloading image:", err)
+		discordFollowUp("❌ Error downloading image: "+err.Error(), s, i)
+		return
+	}
+	original, err = variationImage(original, variationSize)
+	if err != nil {
+		discordFollowUp("❌ Unable to create a variation: "+err.Error(), s, i)
+		return
+	}
+
+	// Variations are only supported by dall-e-2, which has its own sizes
+	ctx, cancel := openaiContext(discordRequestCtx)
+	defer cancel()
+	res, err := ai.Images.NewVariation(ctx, openai.ImageNewVariationParams{
+		Image:          openai.File(bytes.NewReader(original), "image.png", "image/png"),
+		Model:          openai.ImageModelDallE2,
+		Size:           openai.ImageNewVariationParamsSize1024x1024,
+		ResponseFormat: openai.ImageNewVariationParamsResponseFormatB64JSON,
+		N:              openai.Int(1),
+	})
+	if err != nil {
+		log.Println("Error creating image variation:", err)
+		discordFollowUp("❌ Error creating image variation: "+err.Error(), s, i)
+		return
+	}
+	recordImageUsage("discord-image", string(openai.ImageModelDallE2), string(openai.ImageNewVariationParamsSize1024x1024), "", i.ChannelID, i.GuildID, discordInteractionRequester(i).userID, res)
+	discordImageFollowUp("🎨 Ponder Variation", prompt, string(openai.ImageModelDallE2), res.Data[0], s, i)
+}
+
+func discordImageGenerate(prompt string, s *discordgo.Session, i *discordgo.InteractionCreate) {
+	params := imageParams(prompt, 1)
+	model := string(params.Model)
+	// gpt-image models always return base64 and reject response_format
+	if strings.HasPrefix(model, "dall-e") {
+		params.ResponseFormat = openai.ImageGenerateParamsResponseFormatB64JSON
+	}
+
+	refusal, warning := discordCheckBudget("discord-image", discordInteractionRequester(i))
+	if refusal != "" {
+		discordFollowUp(refusal, s, i)
+		return
+	}
+	if warning != "" {
+		discordEphemeralFollowUp(warning, s, i)
+	}
+
+	ctx, cancel := openaiContext(discordRequestCtx)
+	defer cancel()
+	res, err := ai.Images.Generate(ctx, params)
+	if err != nil {
+		log.Println("Error generating image:", err)
+		discordFollowUp("❌ Error generating image: "+err.Error(), s, i)
+		return
+	}
+	recordImageUsage("discord-image", model, string(params.Size), string(params.Quality), i.ChannelID, i.GuildID, discordInteractionRequester(i).userID, res)
+	discordImageFollowUp("🖼️ Ponder Image", prompt, model, res.Data[0], s, i)
+}
+
+// discordImageFollowUp uploads the image as an attachment and displays it in
+// an embed with the prompt, revised prompt and regenerate/variation buttons
+func discordImageFollowUp(title, prompt, model string, img openai.Image, s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data, err := imageData(img)
+	if err != nil {
+		log.Println("Error retrieving image:", err)
+		discordFollowUp("❌ Error retrieving image: "+err.Error(), s, i)
+		return
+	}
+
+	contentType := http.DetectContentType(data)
+	fileName := "ponder." + strings.TrimPrefix(contentType, "image/")
+	if !strings.HasPrefix(contentType, "image/") {
+		fileName = "ponder.png"
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       title,
+		Description: truncate(prompt, 4096),
+		Image:       &discordgo.MessageEmbedImage{URL: "attachment://" + fileName},
+		Footer:      &discordgo.MessageEmbedFooter{Text: model},
+	}
+	if img.RevisedPrompt != "" && img.RevisedPrompt != prompt {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  "Revised Prompt",
+			Value: truncate(img.RevisedPrompt, 1024),
+		})
+	}
+
+	_, err = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Embeds: []*discordgo.MessageEmbed{embed},
+		Files: []*discordgo.File{{
+			Name:        fileName,
+			ContentType: contentType,
+			Reader:      bytes.NewReader(data),
+		}},
+		Components: []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.Button{
+						Label:    "Regenerate",
+						Style:    discordgo.PrimaryButton,
+						CustomID: discordImageRegenerateID,
+						Emoji:    &discordgo.ComponentEmoji{Name: "🔄"},
+					},
+					discordgo.Button{
+						Label:    "Variations",
+						Style:    discordgo.SecondaryButton,
+						CustomID: discordImageVariationID,
+						Emoji:    &discordgo.ComponentEmoji{Name: "🎨"},
+					},
+				},
+			},
+		},
+	})
+	catchErr(err)
+}
+
+// variationSize is the side of the square images dall-e-2 makes variations of
+const variationSize = 1024
+
+// variationImage returns an image as the square PNG variations accept,
+// converting JPEG and GIF images, padding them to a square with transparency
+// and shrinking them to fit size
+func variationImage(data []byte, size int) ([]byte, error) {
+	switch contentType := http.DetectContentType(data); contentType {
+	case "image/png", "image/jpeg", "image/gif":
+	default:
+		return nil, fmt.Errorf("the image must be a png, jpeg or gif, not %s", contentType)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	side := max(bounds.Dx(), bounds.Dy())
+	offset := image.Pt((side-bounds.Dx())/2, (side-bounds.Dy())/2)
+	out := min(side, size)
+	square := image.NewNRGBA(image.Rect(0, 0, out, out))
+	for y := range out {
+		for x := range out {
+			// Nearest pixel in the padded square, outside the image is left transparent
+			p := image.Pt(x*side/out, y*side/out).Sub(offset).Add(bounds.Min)
+			if p.In(bounds) {
+				square.Set(x, y, img.At(p.X, p.Y))
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, square); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// imageData returns the raw bytes of a generated image, decoding base64
+// responses and downloading URL responses
+func imageData(img openai.Image) ([]byte, error) {
+	if img.B64JSON != "" {
+		return base64.StdEncoding.DecodeString(img.B64JSON)
+	}
+	if img.URL != "" {
+		return httpGetBytes(img.URL)
+	}
+	return nil, fmt.Errorf("image response contained no data")
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"testing"
+)
+
+func TestVariationImage(t *testing.T) {
+	image4x4 := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	image4x4.Set(1, 1, color.RGBA{255, 0, 0, 255})
+	wide := image.NewRGBA(image.Rect(0, 0, 8, 4))
+	draw.Draw(wide, wide.Bounds(), image.NewUniform(color.RGBA{0, 0, 255, 255}), image.Point{}, draw.Src)
+	encode := func(encode func(*bytes.Buffer) error) []byte {
+		var buf bytes.Buffer
+		if err := encode(&buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	opaque := func(c color.Color) bool { _, _, _, a := c.RGBA(); return a != 0 }
+	tests := []struct {
+		name    string
+		data    []byte
+		size    int
+		want    int  // side of the square
+		padded  bool // transparent above and below the image
+		wantErr bool
+	}{
+		{"png", encode(func(b *bytes.Buffer) error { return png.Encode(b, image4x4) }), 1024, 4, false, false},
+		{"jpeg", encode(func(b *bytes.Buffer) error { return jpeg.Encode(b, image4x4, nil) }), 1024, 4, false, false},
+		{"gif", encode(func(b *bytes.Buffer) error { return gif.Encode(b, image4x4, nil) }), 1024, 4, false, false},
+		{"wide is padded", encode(func(b *bytes.Buffer) error { return png.Encode(b, wide) }), 1024, 8, true, false},
+		{"large is shrunk", encode(func(b *bytes.Buffer) error { return png.Encode(b, wide) }), 4, 4, true, false},
+		{"webp", []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), 1024, 0, false, true},
+		{"text", []byte("not an image"), 1024, 0, false, true},
+		{"truncated jpeg", []byte("\xff\xd8\xff\xe0 truncated"), 1024, 0, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := variationImage(tt.data, tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("variationImage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if contentType := http.DetectContentType(got); contentType != "image/png" {
+				t.Errorf("variationImage() returned %s", contentType)
+			}
+			decoded, err := png.Decode(bytes.NewReader(got))
+			if err != nil {
+				t.Fatalf("variationImage() returned an invalid png: %v", err)
+			}
+			if want := image.Rect(0, 0, tt.want, tt.want); decoded.Bounds() != want {
+				t.Errorf("variationImage() returned an image of %v, want %v", decoded.Bounds(), want)
+			}
+			if tt.padded {
+				side := tt.want
+				if opaque(decoded.At(0, 0)) || opaque(decoded.At(0, side-1)) || !opaque(decoded.At(side/2, side/2)) {
+					t.Errorf("variationImage() didn't pad the wide image")
+				}
+			}
+		})
+	}
+}
@@ -48,7 +48,7 @@ Throw a few twists in the story but keep with the users original intent.
 `
 
 var generateImages = false
-var adventureMessages []openai.ChatCompletionMessageParamUnion
+var adventureContext *chatContext
 var adventureStage int // 0 = name, 1 = description, 2 = playing
 
 // adventureCmd represents the adventure command
@@ -59,7 +59,7 @@ var adventureCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		adventureStage = 0
 		player = Character{}
-		adventureMessages = []openai.ChatCompletionMessageParamUnion{}
+		adventureContext = newChatContext("adventure", usageSession, "")
 
 		p := tea.NewProgram(
 			newChatHistoryModel(ChatHistoryConfig{
@@ -68,9 +68,13 @@ var adventureCmd = &cobra.Command{
 				InitialMessage: "Welcome adventurer! Please type your name.",
 				UserLabel:      "Unknown Adventurer: ",
 				AssistantLabel: "Narrator:",
-				UserColor:      "86",
-				AssistantColor: "212",
 				CustomHandler:  adventureHandler,
+				Context:        adventureContext,
+				Commands: map[string]chatCommand{
+					"stats":     {"", "Show your character's stats", adventureStatsCommand},
+					"inventory": {"", "Ask the narrator what you're carrying", adventureInventoryCommand},
+					"copy":      {"", "Copy the narrator's last message to the clipboard", copyCommand},
+				},
 			}),
 			tea.WithAltScreen(),
 			tea.WithMouseCellMotion(),
@@ -89,18 +93,7 @@ func init() {
 func adventureHandler(m *chatHistoryModel, userInput string) tea.Cmd {
 	switch adventureStage {
 	case 0: // Name input
-		player = Character{
-			Name:        userInput,
-			Description: "",
-			HP:          100,
-			MP:          100,
-			Level:       1,
-			Strength:    1,
-			Defense:     1,
-			Dexterity:   1,
-			Intellect:   1,
-			Hunger:      0,
-		}
+		player = newCharacter(userInput, "")
 		adventureStage = 1
 		m.textarea.Placeholder = "Describe your character..."
 
@@ -108,15 +101,15 @@ func adventureHandler(m *chatHistoryModel, userInput string) tea.Cmd {
 		m.config.UserLabel = "🗡️  " + player.Name + " 🛡️: "
 
 		// Update the name entry message to avoid duplication (label will be prepended automatically)
-		if len(m.messages) > 0 && m.messages[len(m.messages)-1].role == "user" {
-			m.messages[len(m.messages)-1].content = ""
+		if last := m.history.leaf(); last.role == "user" {
+			last.content = ""
 		}
 
 		// Add narrator response immediately (no API call needed)
 		welcomeMsg := "Welcome " + player.Name + "! Now, please describe your character. Be as detailed as you like.\nYou can include their appearance, personality, background, skills, or anything else that defines them."
-		return func() tea.Msg {
-			return responseMsg{content: welcomeMsg, audio: nil, err: nil}
-		}
+		return m.respond(func(ctx context.Context) responseMsg {
+			return responseMsg{content: welcomeMsg}
+		})
 
 	case 1: // Description input
 		player.Description = userInput
@@ -124,77 +117,153 @@ func adventureHandler(m *chatHistoryModel, userInput string) tea.Cmd {
 		m.textarea.Placeholder = "What do you do?..."
 
 		// Initialize adventure with character
-		return func() tea.Msg {
-			playerString, err := json.Marshal(player)
+		return m.respond(func(ctx context.Context) responseMsg {
+			systemMessage, err := adventureSystemPrompt("YOUR STARTING CHARACTER STATS", player)
 			if err != nil {
 				return responseMsg{err: err}
 			}
+			adventureContext.reset(openai.SystemMessage(systemMessage))
 
-			adventureSystemMessage = adventureSystemMessage + "\n YOUR STARTING CHARACTER STATS:\n" + string(playerString)
-			adventureMessages = []openai.ChatCompletionMessageParamUnion{
-				openai.SystemMessage(adventureSystemMessage),
-			}
-
-			response, audio := adventureResponse("My name is " + player.Name + " start adventure")
-			return responseMsg{content: response, audio: audio, err: nil}
-		}
+			response, audio, err := adventureResponse(ctx, "My name is "+player.Name+" start adventure")
+			return responseMsg{content: response, audio: audio, err: err}
+		})
 
 	case 2: // Playing the adventure
-		return func() tea.Msg {
-			// Manage message history to prevent it from getting too long
-			// Keep system message and last 20 messages
-			if len(adventureMessages) > 21 {
-				adventureMessages = append(adventureMessages[:1], adventureMessages[len(adventureMessages)-20:]...)
-			}
-
-			response, audio := adventureResponse(userInput)
-			return responseMsg{content: response, audio: audio, err: nil}
-		}
+		return m.respond(func(ctx context.Context) responseMsg {
+			response, audio, err := adventureResponse(ctx, userInput)
+			return responseMsg{content: response, audio: audio, err: err}
+		})
 	}
 
 	return nil
 }
 
-func adventureResponse(prompt string) (string, []byte) {
+// adventureStatsCommand handles "/stats", showing the player's character sheet
+func adventureStatsCommand(m *chatHistoryModel, args string) tea.Cmd {
+	if adventureStage < 2 {
+		m.addSystemMessage("Create your character first")
+		return nil
+	}
+	m.addSystemMessage(fmt.Sprintf("📜 %s, level %d\n❤️  HP %.0f  ✨ MP %.0f  🍖 Hunger %.0f\n💪 Strength %.0f  🛡️  Defense %.0f  🏹 Dexterity %.0f  📖 Intellect %.0f",
+		player.Name, player.Level, player.HP, player.MP, player.Hunger,
+		player.Strength, player.Defense, player.Dexterity, player.Intellect))
+	return nil
+}
+
+// adventureInventoryCommand handles "/inventory", the narrator keeps track of
+// what the player picks up in the story
+func adventureInventoryCommand(m *chatHistoryModel, args string) tea.Cmd {
+	if adventureStage < 2 {
+		m.addSystemMessage("Create your character first")
+		return nil
+	}
+	m.history.add("user", "/inventory")
+	m.waiting = true
+	return m.respond(func(ctx context.Context) responseMsg {
+		response, audio, err := adventureResponse(ctx, "What am I carrying? List my inventory briefly, without advancing the story.")
+		return responseMsg{content: response, audio: audio, err: err}
+	})
+}
+
+// newCharacter creates a level 1 character with starting stats
+func newCharacter(name, description string) Character {
+	return Character{
+		Name:        name,
+		Description: description,
+		HP:          100,
+		MP:          100,
+		Level:       1,
+		Strength:    1,
+		Defense:     1,
+		Dexterity:   1,
+		Intellect:   1,
+		Hunger:      0,
+	}
+}
+
+// adventureSystemPrompt appends the character stats to the narrator's system message
+func adventureSystemPrompt(heading string, characters ...Character) (string, error) {
+	var stats []byte
+	var err error
+	if len(characters) == 1 {
+		stats, err = json.Marshal(characters[0])
+	} else {
+		stats, err = json.Marshal(characters)
+	}
+	if err != nil {
+		return "", err
+	}
+	return adventureSystemMessage + "\n " + heading + ":\n" + string(stats), nil
+}
+
+func adventureResponse(ctx context.Context, prompt string) (string, []byte, error) {
 	var audio []byte
 	spinner, _ = ponderSpinner.Start()
-	response := adventureChat(prompt)
-	if narrate {
-		audio = tts(response)
+	response, err := adventureChat(ctx, prompt)
+	if err == nil && narrate {
+		audio, err = tts(ctx, response)
 	}
 	spinner.Stop()
-	if generateImages {
+	if err == nil && generateImages {
 		go adventureImage(response)
 	}
-	return response, audio
+	return response, audio, err
 }
 
-func adventureChat(prompt string) string {
-	adventureMessages = append(adventureMessages, openai.UserMessage(prompt))
+func adventureChat(ctx context.Context, prompt string) (string, error) {
+	generation := adventureContext.currentGeneration() // changes are dropped if the conversation is replaced after a cancel
+	warning, err := checkBudget("adventure", "")
+	if err != nil {
+		return "", err
+	}
+	if warning != "" {
+		notify(ctx, "⚠️  "+warning)
+	}
+
+	if !adventureContext.addTo(generation, openai.UserMessage(prompt)) {
+		return "", context.Canceled
+	}
+	model := viper.GetString("openAI_chat_model")
+	messages := adventureContext.prepare(ctx, model)
 
-	oaiResponse, err := ai.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
-		Messages: adventureMessages,
-		Model:    viper.GetString("openAI_chat_model"),
+	callCtx, cancel := openaiContext(ctx)
+	defer cancel()
+	oaiResponse, err := ai.Chat.Completions.New(callCtx, openai.ChatCompletionNewParams{
+		Messages: messages,
+		Model:    model,
 	})
-	catchErr(err)
+	if err != nil {
+		adventureContext.removeLast(generation) // So the action can be taken again
+		return "", err
+	}
+	recordChatUsage("adventure", usageSession, "", "", oaiResponse)
+	adventureContext.calibrate(messages, oaiResponse.Usage.PromptTokens)
 
 	assistantMessage := oaiResponse.Choices[0].Message.Content
-	adventureMessages = append(adventureMessages, openai.AssistantMessage(assistantMessage))
-	return assistantMessage
+	adventureContext.addTo(generation, openai.AssistantMessage(assistantMessage))
+	return assistantMessage, nil
 }
 
 func adventureImage(prompt string) {
+	warning, err := checkBudget("adventure", "")
+	if err != nil {
+		fmt.Println("💸", err)
+		return
+	}
+	if warning != "" {
+		fmt.Println("⚠️ ", warning)
+	}
+
 	fmt.Println("🖼  Creating Image...")
-	res, err := ai.Images.Generate(context.Background(), openai.ImageGenerateParams{
-		Prompt: prompt,
-		Model:  openai.ImageModel(viper.GetString("openAI_image_model")),
-		Size:   openai.ImageGenerateParamsSize(viper.GetString("openAI_image_size")),
-		N:      openai.Int(1),
-	})
+	ctx, cancel := openaiContext(context.Background())
+	defer cancel()
+	params := imageParams(prompt, 1)
+	res, err := ai.Images.Generate(ctx, params)
 	if err != nil {
 		fmt.Println("❌ Error generating image:", err)
 		return
 	}
+	recordImageUsage("adventure", string(params.Model), string(params.Size), string(params.Quality), usageSession, "", "", res)
 
 	url := res.Data[0].URL
 
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// budgetLimit is a daily and monthly spending limit in USD, zero means no limit
+type budgetLimit struct {
+	Daily   float64 `mapstructure:"daily"`
+	Monthly float64 `mapstructure:"monthly"`
+}
+
+// budgetScope is a budget and the spending in the ledger it applies to
+type budgetScope struct {
+	name  string
+	limit budgetLimit
+	key   string // of the spending totals in the ledger
+}
+
+// Warnings already given, by scope and period, so each is only shown once
+var budgetWarned = struct {
+	mu   sync.Mutex
+	keys map[string]bool
+}{keys: map[string]bool{}}
+
+// checkBudget checks the global, command and guild budgets before a request,
+// returning an error if any has been spent, and a warning the first time
+// spending in a period passes budget_warnAt
+func checkBudget(command, guild string) (warning string, err error) {
+	scopes := budgetScopes(command, guild)
+	if len(scopes) == 0 {
+		return "", nil
+	}
+
+	now := time.Now()
+	var warnings []string
+	for _, scope := range scopes {
+		daily, monthly, err := ledger.spent(scope.key)
+		if err != nil {
+			return "", err
+		}
+
+		for _, period := range []struct {
+			name  string
+			key   string
+			spent float64
+			limit float64
+		}{
+			{"daily", now.Format(time.DateOnly), daily, scope.limit.Daily},
+			{"monthly", now.Format("2006-01"), monthly, scope.limit.Monthly},
+		} {
+			if period.limit <= 0 {
+				continue
+			}
+			if period.spent >= period.limit {
+				return "", fmt.Errorf("%s %s budget of $%.2f reached ($%.2f spent)", scope.name, period.name, period.limit, period.spent)
+			}
+			if period.spent >= period.limit*viper.GetFloat64("budget_warnAt") &&
+				budgetWarnOnce(scope.name+":"+period.name+":"+period.key) {
+				warnings = append(warnings, fmt.Sprintf("%s %s budget is %.0f%% spent ($%.2f of $%.2f)",
+					scope.name, period.name, period.spent/period.limit*100, period.spent, period.limit))
+			}
+		}
+	}
+	return strings.Join(warnings, ", "), nil
+}
+
+// budgetScopes returns the budgets configured for a command and guild
+func budgetScopes(command, guild string) []budgetScope {
+	var scopes []budgetScope
+	global := budgetLimit{
+		Daily:   viper.GetFloat64("budget_daily"),
+		Monthly: viper.GetFloat64("budget_monthly"),
+	}
+	if global != (budgetLimit{}) {
+		scopes = append(scopes, budgetScope{
+			name:  "Global",
+			limit: global,
+			key:   "",
+		})
+	}
+
+	var commands map[string]budgetLimit
+	catchErr(viper.UnmarshalKey("budget_commands", &commands))
+	if limit, ok := commands[command]; ok {
+		scopes = append(scopes, budgetScope{
+			name:  fmt.Sprintf("%q command", command),
+			limit: limit,
+			key:   "command:" + command,
+		})
+	}
+
+	if guild != "" {
+		var guilds map[string]budgetLimit
+		catchErr(viper.UnmarshalKey("budget_guilds", &guilds))
+		limit, ok := guilds[guild]
+		if !ok {
+			limit = budgetLimit{
+				Daily:   viper.GetFloat64("budget_guildDaily"),
+				Monthly: viper.GetFloat64("budget_guildMonthly"),
+			}
+		}
+		if limit != (budgetLimit{}) {
+			scopes = append(scopes, budgetScope{
+				name:  "Server",
+				limit: limit,
+				key:   "guild:" + guild,
+			})
+		}
+	}
+	return scopes
+}
+
+// budgetWarnOnce reports whether a warning has not been given yet, marking it as given
+func budgetWarnOnce(key string) bool {
+	budgetWarned.mu.Lock()
+	defer budgetWarned.mu.Unlock()
+	if budgetWarned.keys[key] {
+		return false
+	}
+	budgetWarned.keys[key] = true
+	return true
+}
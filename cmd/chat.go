@@ -6,15 +6,25 @@ Copyright © 2023 Kevin.Jayne@iCloud.com
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"slices"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/openai/openai-go/v3"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
+// Settings for the chat session, changed by templates and personas
+var chatSettings struct {
+	model       string
+	temperature *float64
+	maxTokens   int64
+}
+
 func init() {
 	rootCmd.AddCommand(chatCmd)
+	chatCmd.Flags().StringArrayVar(&imageFiles, "image", nil, "Image file to send with the prompt to a vision model (repeatable)")
 }
 
 // chatCmd represents the chat command
@@ -26,8 +36,40 @@ var chatCmd = &cobra.Command{
 		if len(args) > 0 {
 			prompt = args[0]
 		}
+		for _, file := range imageFiles {
+			catchErr(attachImage(file), "fatal")
+		}
+		if jsonSchemaFile != "" || jsonOutput {
+			if err := jsonRun(cmd.Context(), prompt); err != nil {
+				fmt.Fprintln(os.Stderr, "💀", err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		var notes []string
+		for _, path := range contextFilePaths {
+			note, err := addContextFiles(ponderContext, path)
+			catchErr(err, "fatal")
+			notes = append(notes, note)
+		}
+
+		model := initialChatHistoryModel()
+		for _, note := range notes {
+			model.addSystemMessage(note)
+		}
+		if personaName != "" {
+			persona, err := findPersona(personaName)
+			catchErr(err, "fatal")
+			if cmd.Flags().Changed("voice") {
+				persona.Voice = "" // --voice takes precedence
+			}
+			applyChatPersona(persona)
+			persona.applyTo(&model.config)
+		}
+
 		p := tea.NewProgram(
-			initialChatHistoryModel(),
+			model,
 			tea.WithAltScreen(),
 			tea.WithMouseCellMotion(),
 		)
@@ -37,29 +79,68 @@ var chatCmd = &cobra.Command{
 	},
 }
 
-func chatResponse(prompt string) (string, []byte) {
+func chatResponse(ctx context.Context, prompt string) (string, []byte, error) {
 	var audio []byte
-	var response string
 	spinner, _ = ponderSpinner.Start()
-	response = chatCompletion(prompt)
-	if narrate {
-		audio = tts(response)
+	defer spinner.Stop()
+	response, err := chatCompletion(ctx, prompt)
+	if err == nil && narrate {
+		audio, err = tts(ctx, response)
 	}
-	spinner.Stop()
-	return response, audio
+	return response, audio, err
 }
 
-func chatCompletion(prompt string) string {
-	ponderMessages = append(ponderMessages, openai.UserMessage(prompt))
+func chatCompletion(ctx context.Context, prompt string) (string, error) {
+	generation := ponderContext.currentGeneration() // changes are dropped if the conversation is replaced after a cancel
+	warning, err := checkBudget("chat", "")
+	if err != nil {
+		return "", err
+	}
+	if warning != "" {
+		notify(ctx, "⚠️  "+warning)
+	}
+
+	var excerpts []openai.ChatCompletionMessageParamUnion
+	if kbName != "" {
+		message, err := kbExcerpts(ctx, prompt)
+		if err != nil {
+			return "", err
+		}
+		excerpts = append(excerpts, message)
+	}
+	images, resent := ctx.Value(imagesKey{}).([]string)
+	if !resent {
+		images, pendingImages = pendingImages, nil
+	}
+	if !ponderContext.addTo(generation, userMessage(prompt, images)) {
+		return "", context.Canceled
+	}
+	model := chatModel(ponderContext.history())
+	messages := ponderContext.prepare(ctx, model)
+	messages = slices.Insert(messages, len(messages)-1, excerpts...) // only for this question, so they don't pile up
 
 	// Send the messages to OpenAI
-	res, err := ai.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
-		Messages: ponderMessages,
-		Model:    viper.GetString("openAI_chat_model"),
-	})
-	catchErr(err, "fatal")
+	callCtx, cancel := openaiContext(ctx)
+	defer cancel()
+	params := openai.ChatCompletionNewParams{
+		Messages: messages,
+		Model:    model,
+	}
+	if chatSettings.temperature != nil {
+		params.Temperature = openai.Float(*chatSettings.temperature)
+	}
+	if chatSettings.maxTokens > 0 {
+		params.MaxCompletionTokens = openai.Int(chatSettings.maxTokens)
+	}
+	res, err := ai.Chat.Completions.New(callCtx, params)
+	if err != nil {
+		ponderContext.removeLast(generation) // So the prompt can be sent again
+		return "", err
+	}
+	recordChatUsage("chat", usageSession, "", "", res)
+	ponderContext.calibrate(messages, res.Usage.PromptTokens)
 
 	assistantMessage := res.Choices[0].Message.Content
-	ponderMessages = append(ponderMessages, openai.AssistantMessage(assistantMessage))
-	return assistantMessage
+	ponderContext.addTo(generation, openai.AssistantMessage(assistantMessage))
+	return assistantMessage, nil
 }
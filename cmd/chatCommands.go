@@ -0,0 +1,303 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/openai/openai-go/v3"
+	"github.com/spf13/viper"
+)
+
+// chatCommand is a "/name args" command entered in the chat textarea
+type chatCommand struct {
+	Usage       string // arguments, shown by /help
+	Description string
+	Run         func(m *chatHistoryModel, args string) tea.Cmd
+}
+
+// chatSession is a conversation saved with /save, stored in chat_sessionsDir as <name>.json
+type chatSession struct {
+	Time     time.Time            `json:"time"`
+	Model    string               `json:"model,omitempty"`
+	System   string               `json:"system"`
+	Messages []chatSessionMessage `json:"messages"`
+}
+
+type chatSessionMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// ponderChatCommands are the commands of "ponder chat"
+func ponderChatCommands() map[string]chatCommand {
+	return map[string]chatCommand{
+		"model":       {"[name]", "Show or change the model", modelCommand},
+		"system":      {"[prompt]", "Show or change the system prompt", systemCommand},
+		"temperature": {"[value|default]", "Show or change the temperature", temperatureCommand},
+		"clear":       {"", "Start over, keeping the system prompt and files", clearCommand},
+		"save":        {"[name]", "Save the conversation", saveCommand},
+		"load":        {"[name]", "Load a saved conversation, or list them", loadCommand},
+		"retry":       {"", "Regenerate the last answer, keeping the old one as an alternative", retryCommand},
+		"undo":        {"", "Remove the last question and its answers", undoCommand},
+		"copy":        {"[n]", "Copy the last answer, or its nth code block, to the clipboard", copyCommand},
+		"image":       {"<prompt>", "Generate an image", imageCommand},
+		"tts":         {"[text]", "Read the last answer, or text, aloud", ttsCommand},
+		"attach":      {"<image path>", "Attach an image to your next message", attachCommand},
+		"add":         {"<path>", "Add a file or directory to the context", addCommand},
+		"files":       {"", "List the attached files", filesCommand},
+		"remove":      {"<path|all>", "Remove attached files", removeCommand},
+		"template":    {"<name> [key=value ...]", "Use a prompt template", templateCommand},
+		"persona":     {"<name>", "Switch persona", personaCommand},
+	}
+}
+
+// helpCommand handles "/help", listing the commands of the current chat
+func helpCommand(m *chatHistoryModel, args string) tea.Cmd {
+	var b strings.Builder
+	b.WriteString("Commands:")
+	for _, name := range m.commandNames() {
+		command := m.config.Commands[name]
+		b.WriteString("\n/" + name)
+		if command.Usage != "" {
+			b.WriteString(" " + command.Usage)
+		}
+		if command.Description != "" {
+			fmt.Fprintf(&b, " - %s", command.Description)
+		}
+	}
+	b.WriteString("\nPress Tab to complete a command")
+	m.addSystemMessage(b.String())
+	return nil
+}
+
+// commandNames returns the names of the chat's commands, sorted
+func (m *chatHistoryModel) commandNames() []string {
+	names := make([]string, 0, len(m.config.Commands))
+	for name := range m.config.Commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// completeCommand completes a partly typed "/name" to the longest prefix
+// shared by the matching commands, adding a space once it's unique
+func (m *chatHistoryModel) completeCommand() {
+	input := m.textarea.Value()
+	if !strings.HasPrefix(input, "/") || strings.ContainsAny(input, " \n") {
+		return
+	}
+	matches := m.matchCommands(strings.TrimPrefix(input, "/"))
+	if len(matches) == 0 {
+		return
+	}
+	if len(matches) == 1 {
+		m.textarea.SetValue("/" + matches[0] + " ")
+		return
+	}
+	prefix := matches[0]
+	for _, match := range matches[1:] {
+		for !strings.HasPrefix(match, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	m.textarea.SetValue("/" + prefix)
+}
+
+// matchCommands returns the command names starting with prefix
+func (m *chatHistoryModel) matchCommands(prefix string) []string {
+	var matches []string
+	for _, name := range m.commandNames() {
+		if strings.HasPrefix(name, prefix) {
+			matches = append(matches, name)
+		}
+	}
+	return matches
+}
+
+func modelCommand(m *chatHistoryModel, args string) tea.Cmd {
+	if args != "" {
+		chatSettings.model = args
+	}
+	m.addSystemMessage("🧠 Model: " + chatModel(m.config.Context.history()))
+	return nil
+}
+
+func systemCommand(m *chatHistoryModel, args string) tea.Cmd {
+	if args == "" {
+		m.addSystemMessage("System prompt: " + m.config.Context.systemMessage())
+		return nil
+	}
+	m.config.Context.setSystemMessage(openai.DeveloperMessage(args))
+	m.addSystemMessage("📝 System prompt changed")
+	return nil
+}
+
+func temperatureCommand(m *chatHistoryModel, args string) tea.Cmd {
+	switch args {
+	case "":
+	case "default":
+		chatSettings.temperature = nil
+	default:
+		temperature, err := strconv.ParseFloat(args, 64)
+		if err != nil || temperature < 0 || temperature > 2 {
+			m.addSystemMessage("❌ Temperature must be a number from 0 to 2")
+			return nil
+		}
+		chatSettings.temperature = &temperature
+	}
+	if chatSettings.temperature == nil {
+		m.addSystemMessage("🌡️  Temperature: model default")
+	} else {
+		m.addSystemMessage(fmt.Sprintf("🌡️  Temperature: %g", *chatSettings.temperature))
+	}
+	return nil
+}
+
+func clearCommand(m *chatHistoryModel, args string) tea.Cmd {
+	m.config.Context.clear()
+	m.history = newChatTree()
+	m.selected = nil
+	m.addSystemMessage("🧹 Conversation cleared")
+	return nil
+}
+
+func sessionPath(name string) string {
+	return filepath.Join(expandHome(viper.GetString("chat_sessionsDir")), name+".json")
+}
+
+func saveCommand(m *chatHistoryModel, args string) tea.Cmd {
+	name := args
+	if name == "" {
+		name = usageSession
+	}
+	session := chatSession{
+		Time:   time.Now(),
+		Model:  chatSettings.model,
+		System: m.config.Context.systemMessage(),
+	}
+	for _, node := range m.history.path() {
+		session.Messages = append(session.Messages, chatSessionMessage{node.role, node.content})
+	}
+
+	path := sessionPath(name)
+	data, err := json.MarshalIndent(session, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	}
+	if err == nil {
+		err = os.WriteFile(path, data, 0o600)
+	}
+	if err != nil {
+		m.addSystemMessage("❌ " + err.Error())
+		return nil
+	}
+	m.addSystemMessage(fmt.Sprintf("💾 Saved as %q, load it with /load %s", name, name))
+	return nil
+}
+
+func loadCommand(m *chatHistoryModel, args string) tea.Cmd {
+	if args == "" {
+		paths, _ := filepath.Glob(sessionPath("*"))
+		var names []string
+		for _, path := range paths {
+			names = append(names, strings.TrimSuffix(filepath.Base(path), ".json"))
+		}
+		if len(names) == 0 {
+			m.addSystemMessage("No saved conversations, save one with /save [name]")
+			return nil
+		}
+		m.addSystemMessage("Saved conversations: " + strings.Join(names, ", ") + "\nUsage: /load <name>")
+		return nil
+	}
+
+	data, err := os.ReadFile(sessionPath(args))
+	if err != nil {
+		m.addSystemMessage("❌ " + err.Error())
+		return nil
+	}
+	var session chatSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		m.addSystemMessage(fmt.Sprintf("❌ Invalid conversation %q: %v", args, err))
+		return nil
+	}
+
+	if session.System != "" {
+		m.config.Context.setSystemMessage(openai.DeveloperMessage(session.System))
+	}
+	if session.Model != "" {
+		chatSettings.model = session.Model
+	}
+	m.history = newChatTree()
+	m.selected = nil
+	for _, message := range session.Messages {
+		if message.Role == "user" || message.Role == "assistant" {
+			m.history.add(message.Role, message.Content)
+		}
+	}
+	m.syncContext(nil)
+	m.addSystemMessage(fmt.Sprintf("📂 Loaded %q, %d messages", args, len(m.history.path())))
+	return nil
+}
+
+func retryCommand(m *chatHistoryModel, args string) tea.Cmd {
+	return m.regenerate()
+}
+
+func undoCommand(m *chatHistoryModel, args string) tea.Cmd {
+	question := m.history.last("user")
+	if question == nil {
+		m.addSystemMessage("Nothing to undo")
+		return nil
+	}
+	question.remove()
+	m.selected = nil
+	m.syncContext(nil)
+	m.addSystemMessage("↩️  Removed the last question and its answers")
+	return nil
+}
+
+func imageCommand(m *chatHistoryModel, args string) tea.Cmd {
+	if args == "" {
+		m.addSystemMessage("Usage: /image <prompt>")
+		return nil
+	}
+	m.addSystemMessage("🖼  Creating Image...")
+	m.waiting = true
+	return m.respond(func(ctx context.Context) responseMsg {
+		url, err := generateImageURL(ctx, args)
+		if err != nil {
+			return responseMsg{err: err, system: true}
+		}
+		return responseMsg{content: "🌐 Image URL: " + url, system: true}
+	})
+}
+
+func ttsCommand(m *chatHistoryModel, args string) tea.Cmd {
+	text := args
+	if text == "" {
+		last := m.history.last("assistant")
+		if last == nil {
+			m.addSystemMessage("Usage: /tts [text], reads the last answer without text")
+			return nil
+		}
+		text = last.content
+	}
+	m.waiting = true
+	return m.respond(func(ctx context.Context) responseMsg {
+		audio, err := tts(ctx, text)
+		if err != nil {
+			return responseMsg{err: err, system: true}
+		}
+		go playAudio(audio)
+		return responseMsg{content: "🔊 Playing audio", system: true}
+	})
+}
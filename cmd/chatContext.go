@@ -0,0 +1,415 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/openai/openai-go/v3"
+	"github.com/spf13/viper"
+)
+
+// Estimated tokens for an attached image and the formatting around each message
+const (
+	imageTokens   = 765
+	messageTokens = 4
+)
+
+// chatContext keeps a conversation within the model's context window.
+// Pinned messages, such as the system prompt and character sheets, are always
+// sent, and older turns are folded into a running summary as the conversation
+// approaches the limit
+type chatContext struct {
+	mu         sync.Mutex
+	pinned     []openai.ChatCompletionMessageParamUnion
+	files      []contextFile // attached files, sent after the pinned messages
+	summary    string
+	messages   []openai.ChatCompletionMessageParamUnion
+	model      string  // model of the last request, for reporting usage
+	scale      float64 // actual tokens per estimated token, calibrated from responses
+	generation int     // changes when the conversation is replaced, so late changes meant for another are ignored
+
+	// Where summarization usage is recorded in the ledger
+	command, session, guild string
+}
+
+// contextFile is a file attached to the conversation with /add or --file
+type contextFile struct {
+	path    string
+	message openai.ChatCompletionMessageParamUnion
+}
+
+// contextWindow is the context size of a model, configured in context_windows
+type contextWindow struct {
+	Model  string `mapstructure:"model"`
+	Tokens int    `mapstructure:"tokens"`
+}
+
+func newChatContext(command, session, guild string, pinned ...openai.ChatCompletionMessageParamUnion) *chatContext {
+	return &chatContext{
+		pinned:  pinned,
+		scale:   1,
+		command: command,
+		session: session,
+		guild:   guild,
+	}
+}
+
+// reset clears the conversation and attached files, and replaces the pinned messages
+func (c *chatContext) reset(pinned ...openai.ChatCompletionMessageParamUnion) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pinned, c.files, c.summary, c.messages = pinned, nil, "", nil
+	c.generation++
+}
+
+// pin adds messages that are always sent, and never summarized or trimmed
+func (c *chatContext) pin(messages ...openai.ChatCompletionMessageParamUnion) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pinned = append(c.pinned, messages...)
+}
+
+// setSystemMessage replaces the first pinned message, the system prompt
+func (c *chatContext) setSystemMessage(message openai.ChatCompletionMessageParamUnion) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pinned) == 0 {
+		c.pinned = []openai.ChatCompletionMessageParamUnion{message}
+		return
+	}
+	c.pinned = append([]openai.ChatCompletionMessageParamUnion{message}, c.pinned[1:]...)
+}
+
+// attachFile adds a file that is always sent, replacing an earlier version of it
+func (c *chatContext) attachFile(path string, message openai.ChatCompletionMessageParamUnion) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, file := range c.files {
+		if file.path == path {
+			c.files[i].message = message
+			return
+		}
+	}
+	c.files = append(c.files, contextFile{path, message})
+}
+
+// detachFiles removes the attached file at path, all files under it if it's
+// a directory, or every file if path is empty, returning the paths removed
+func (c *chatContext) detachFiles(path string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var removed []string
+	kept := c.files[:0]
+	for _, file := range c.files {
+		if path == "" || file.path == path || strings.HasPrefix(file.path, strings.TrimSuffix(path, "/")+"/") {
+			removed = append(removed, file.path)
+			continue
+		}
+		kept = append(kept, file)
+	}
+	c.files = kept
+	return removed
+}
+
+// attachedFiles returns the paths of the attached files and their estimated tokens
+func (c *chatContext) attachedFiles() (paths []string, tokens []int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, file := range c.files {
+		paths = append(paths, file.path)
+		tokens = append(tokens, c.tokens([]openai.ChatCompletionMessageParamUnion{file.message}))
+	}
+	return paths, tokens
+}
+
+// add appends messages to the conversation
+func (c *chatContext) add(messages ...openai.ChatCompletionMessageParamUnion) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = append(c.messages, messages...)
+}
+
+// currentGeneration identifies the conversation, a request takes it when it
+// starts so a cancelled request finishing late can't change the next one
+func (c *chatContext) currentGeneration() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// addTo appends messages if the conversation is still generation, reporting whether it was
+func (c *chatContext) addTo(generation int, messages ...openai.ChatCompletionMessageParamUnion) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation != generation {
+		return false
+	}
+	c.messages = append(c.messages, messages...)
+	return true
+}
+
+// removeLast removes the most recent message, such as a prompt whose request
+// failed, if the conversation is still generation
+func (c *chatContext) removeLast(generation int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation == generation && len(c.messages) > 0 {
+		c.messages = c.messages[:len(c.messages)-1]
+	}
+}
+
+// lastUserMessage returns the most recent user message in the conversation, or nil
+func (c *chatContext) lastUserMessage() *openai.ChatCompletionMessageParamUnion {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := len(c.messages) - 1; i >= 0; i-- {
+		if c.messages[i].OfUser != nil {
+			message := c.messages[i]
+			return &message
+		}
+	}
+	return nil
+}
+
+// clear forgets the conversation, keeping the pinned messages and attached files
+func (c *chatContext) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.summary, c.messages = "", nil
+	c.generation++
+}
+
+// setMessages replaces the conversation, such as when switching to another branch of it
+func (c *chatContext) setMessages(messages []openai.ChatCompletionMessageParamUnion) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.summary, c.messages = "", messages
+	c.generation++
+}
+
+// systemMessage returns the text of the system prompt
+func (c *chatContext) systemMessage() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pinned) == 0 {
+		return ""
+	}
+	_, text, _ := messageText(c.pinned[0])
+	return text
+}
+
+// history returns the pinned messages and the conversation that hasn't been summarized
+func (c *chatContext) history() []openai.ChatCompletionMessageParamUnion {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append(append([]openai.ChatCompletionMessageParamUnion{}, c.pinned...), c.messages...)
+}
+
+// prepare returns the messages to send to the model, first summarizing older
+// turns if the conversation has grown past context_summarizeAt of its window.
+// The lock isn't held while summarizing, so the TUI can show the usage and
+// cancel the request
+func (c *chatContext) prepare(ctx context.Context, model string) []openai.ChatCompletionMessageParamUnion {
+	c.mu.Lock()
+	c.model = model
+	limit := int(float64(modelContextLimit(model)) * viper.GetFloat64("context_summarizeAt"))
+	keep := max(viper.GetInt("context_keepMessages"), 1)
+	if c.tokens(c.request()) <= limit || len(c.messages) <= keep {
+		defer c.mu.Unlock()
+		c.trim(limit)
+		return c.request()
+	}
+	folded := slices.Clone(c.messages[:len(c.messages)-keep])
+	previous, generation := c.summary, c.generation
+	c.mu.Unlock()
+
+	summaryCtx, cancel := openaiContext(ctx)
+	summary, err := c.summarize(summaryCtx, previous, folded)
+	cancel()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if errors.Is(err, context.Canceled) {
+		return c.request()
+	}
+	if c.generation != generation || c.summary != previous || len(c.messages) < len(folded) {
+		// The conversation was cleared, loaded or summarized meanwhile
+		c.trim(limit)
+		return c.request()
+	}
+	if err != nil {
+		catchErr(fmt.Errorf("error summarizing conversation, dropping older messages instead: %w", err))
+	} else {
+		c.summary = summary
+	}
+	c.messages = c.messages[len(folded):]
+	c.trim(limit)
+	return c.request()
+}
+
+// trim drops the oldest messages if the most recent still don't fit in
+// limit, the caller must hold the lock
+func (c *chatContext) trim(limit int) {
+	for len(c.messages) > 1 && c.tokens(c.request()) > limit {
+		c.messages = c.messages[1:]
+	}
+}
+
+// calibrate adjusts the token estimate using the prompt tokens the API reported for the messages sent
+func (c *chatContext) calibrate(sent []openai.ChatCompletionMessageParamUnion, promptTokens int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if estimate := estimateTokens(sent); estimate > 0 && promptTokens > 0 {
+		c.scale = float64(promptTokens) / float64(estimate)
+	}
+}
+
+// usage returns the estimated tokens in the context and the model's limit
+func (c *chatContext) usage() (tokens, limit int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	model := c.model
+	if model == "" {
+		model = viper.GetString("openAI_chat_model")
+	}
+	return c.tokens(c.request()), modelContextLimit(model)
+}
+
+// request assembles the pinned messages, summary and conversation, the caller must hold the lock
+func (c *chatContext) request() []openai.ChatCompletionMessageParamUnion {
+	messages := append([]openai.ChatCompletionMessageParamUnion{}, c.pinned...)
+	for _, file := range c.files {
+		messages = append(messages, file.message)
+	}
+	if c.summary != "" {
+		messages = append(messages, openai.SystemMessage("Summary of the earlier conversation:\n"+c.summary))
+	}
+	return append(messages, c.messages...)
+}
+
+// tokens estimates the tokens in messages, scaled by the calibration, the caller must hold the lock
+func (c *chatContext) tokens(messages []openai.ChatCompletionMessageParamUnion) int {
+	return int(float64(estimateTokens(messages)) * c.scale)
+}
+
+// summarize folds messages into the summary so far, without the lock as it calls the API
+func (c *chatContext) summarize(ctx context.Context, summary string, messages []openai.ChatCompletionMessageParamUnion) (string, error) {
+	var transcript strings.Builder
+	if summary != "" {
+		transcript.WriteString("Summary so far:\n" + summary + "\n\nConversation since:\n")
+	}
+	for _, message := range messages {
+		role, text, images := messageText(message)
+		if images > 0 {
+			text += fmt.Sprintf(" [%d image(s)]", images)
+		}
+		transcript.WriteString(role + ": " + text + "\n")
+	}
+
+	res, err := ai.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
+		Model: viper.GetString("context_summaryModel"),
+		Messages: []openai.ChatCompletionMessageParamUnion{
+			openai.SystemMessage(viper.GetString("context_summaryPrompt")),
+			openai.UserMessage(transcript.String()),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	recordChatUsage(c.command, c.session, c.guild, "", res)
+	return res.Choices[0].Message.Content, nil
+}
+
+// estimateTokens approximates the tokens in messages, about four characters
+// of English per token, one per character for other scripts
+func estimateTokens(messages []openai.ChatCompletionMessageParamUnion) int {
+	var tokens float64
+	for _, message := range messages {
+		_, text, images := messageText(message)
+		ascii := 0
+		for i := 0; i < len(text); i++ {
+			if text[i] < utf8.RuneSelf {
+				ascii++
+			}
+		}
+		tokens += float64(ascii)/4 + float64(utf8.RuneCountInString(text)-ascii)
+		tokens += float64(images*imageTokens + messageTokens)
+	}
+	return int(tokens)
+}
+
+// messageText returns the role and text of a message, and the number of images attached
+func messageText(message openai.ChatCompletionMessageParamUnion) (role, text string, images int) {
+	switch {
+	case message.OfDeveloper != nil:
+		role = "developer"
+	case message.OfSystem != nil:
+		role = "system"
+	case message.OfUser != nil:
+		role = "user"
+	case message.OfAssistant != nil:
+		role = "assistant"
+	case message.OfTool != nil:
+		role = "tool"
+	}
+	var parts []string
+	switch content := message.GetContent().AsAny().(type) {
+	case *string:
+		parts = append(parts, *content)
+	case *[]openai.ChatCompletionContentPartTextParam:
+		for _, part := range *content {
+			parts = append(parts, part.Text)
+		}
+	case *[]openai.ChatCompletionContentPartUnionParam:
+		for _, part := range *content {
+			if part.OfText != nil {
+				parts = append(parts, part.OfText.Text)
+			}
+			if part.OfImageURL != nil {
+				images++
+			}
+		}
+	case *[]openai.ChatCompletionAssistantMessageParamContentArrayOfContentPartUnion:
+		for _, part := range *content {
+			if part.OfText != nil {
+				parts = append(parts, part.OfText.Text)
+			}
+		}
+	}
+	return role, strings.Join(parts, "\n"), images
+}
+
+// modelContextLimit returns the context window of a model from context_windows,
+// matching by longest prefix, capped at context_maxTokens if set
+func modelContextLimit(model string) int {
+	var windows []contextWindow
+	catchErr(viper.UnmarshalKey("context_windows", &windows))
+
+	limit := viper.GetInt("context_defaultWindow")
+	var best string
+	for _, window := range windows {
+		if strings.HasPrefix(model, window.Model) && len(window.Model) >= len(best) {
+			best, limit = window.Model, window.Tokens
+		}
+	}
+	if maxTokens := viper.GetInt("context_maxTokens"); maxTokens > 0 && maxTokens < limit {
+		limit = maxTokens
+	}
+	return limit
+}
+
+// formatTokens abbreviates a token count, e.g. 12.3k
+func formatTokens(tokens int) string {
+	switch {
+	case tokens >= 1_000_000:
+		return fmt.Sprintf("%.1fM", float64(tokens)/1_000_000)
+	case tokens >= 1000:
+		return fmt.Sprintf("%.1fk", float64(tokens)/1000)
+	}
+	return fmt.Sprint(tokens)
+}
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/option"
+	"github.com/spf13/viper"
+)
+
+// fakeSummarizer serves chat completions answering "summary", each waiting
+// for a value on release
+func fakeSummarizer(t *testing.T, release <-chan struct{}) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"1","object":"chat.completion","model":"test","choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":"summary"}}],"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`))
+	}))
+	t.Cleanup(srv.Close)
+	ai = openai.NewClient(option.WithBaseURL(srv.URL), option.WithAPIKey("x"))
+	viper.Set("usage_ledgerFile", filepath.Join(t.TempDir(), "usage.jsonl"))
+	viper.Set("context_summarizeAt", 1.0)
+	viper.Set("context_defaultWindow", 100)
+	viper.Set("context_keepMessages", 1)
+	t.Cleanup(func() {
+		for _, key := range []string{"usage_ledgerFile", "context_summarizeAt", "context_defaultWindow", "context_keepMessages"} {
+			viper.Set(key, nil)
+		}
+	})
+}
+
+func TestPrepareSummarizesWithoutTheLock(t *testing.T) {
+	tests := []struct {
+		name    string
+		meddle  func(c *chatContext) // while summarizing
+		summary string
+		want    []string // messages left
+	}{
+		{"summarized", func(c *chatContext) {}, "summary", []string{"last"}},
+		{"added meanwhile", func(c *chatContext) { c.add(openai.UserMessage("new")) }, "summary", []string{"last", "new"}},
+		{"cleared meanwhile", func(c *chatContext) { c.clear() }, "", nil},
+		{"replaced meanwhile", func(c *chatContext) {
+			c.setMessages([]openai.ChatCompletionMessageParamUnion{openai.UserMessage("other")})
+		}, "", []string{"other"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			fakeSummarizer(t, release)
+			c := newChatContext("chat", "", "")
+			for range 3 {
+				c.add(openai.UserMessage(strings.Repeat("word ", 100)))
+			}
+			c.add(openai.UserMessage("last"))
+
+			done := make(chan struct{})
+			go func() {
+				c.prepare(context.Background(), "test")
+				close(done)
+			}()
+			usage := make(chan struct{})
+			go func() {
+				time.Sleep(50 * time.Millisecond) // let prepare start summarizing
+				c.usage()
+				tt.meddle(c)
+				close(usage)
+			}()
+			select {
+			case <-usage:
+			case <-time.After(5 * time.Second):
+				close(release)
+				t.Fatal("usage() blocked while summarizing")
+			}
+			close(release)
+			<-done
+
+			if c.summary != tt.summary {
+				t.Errorf("summary = %q, want %q", c.summary, tt.summary)
+			}
+			var got []string
+			for _, message := range c.messages {
+				_, text, _ := messageText(message)
+				got = append(got, text)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("messages = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChatContextGeneration(t *testing.T) {
+	tests := []struct {
+		name    string
+		replace func(c *chatContext) // after a request starts
+		want    []string
+	}{
+		{"not replaced", func(c *chatContext) {}, []string{"old", "late"}},
+		{"cleared", func(c *chatContext) { c.clear() }, nil},
+		{"rebuilt", func(c *chatContext) {
+			c.setMessages([]openai.ChatCompletionMessageParamUnion{openai.UserMessage("new")})
+		}, []string{"new"}},
+		{"reset", func(c *chatContext) { c.reset() }, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newChatContext("chat", "", "")
+			c.add(openai.UserMessage("old"))
+			generation := c.currentGeneration()
+			tt.replace(c)
+			c.removeLast(generation)
+			c.addTo(generation, openai.UserMessage("old"), openai.UserMessage("late"))
+
+			var got []string
+			for _, message := range c.messages {
+				_, text, _ := messageText(message)
+				got = append(got, text)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("messages = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,35 +1,50 @@
 package cmd
 
 import (
+	"cmp"
+	"context"
+	"errors"
 	"fmt"
 	"strings"
 
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/viper"
 )
 
 // UI configuration constants
 const (
-	textareaHeight = 3
+	textareaHeight = 3 // default for chat_inputHeight
 	textareaWidth  = 4 // padding for width
 	titleLines     = 1
 	helpLines      = 1
 	charLimit      = 10000
-
-	// Colors
-	titleColor     = "212"
-	userColor      = "86"
-	assistantColor = "212"
-	helpColor      = "240"
-	systemColor    = "240"
+	horizontalStep = 4 // columns scrolled by Ctrl+←/→, for wide code blocks
 )
 
 type responseMsg struct {
 	content string
 	audio   []byte
 	err     error
+	request int  // which request this responds to, so cancelled requests are ignored
+	system  bool // show the content as a system message, for commands
+	notes   []string
+}
+
+// notesKey is the context key of the notes collected while a request runs
+type notesKey struct{}
+
+// notify shows a note as a system message after the response, when called
+// while the chat TUI handles a request, or prints it otherwise
+func notify(ctx context.Context, note string) {
+	if notes, ok := ctx.Value(notesKey{}).(*[]string); ok {
+		*notes = append(*notes, note)
+		return
+	}
+	fmt.Println(note)
 }
 
 // ChatHistoryConfig allows customization of the chat history model
@@ -41,20 +56,37 @@ type ChatHistoryConfig struct {
 	AssistantLabel  string
 	UserColor       string
 	AssistantColor  string
-	ResponseHandler func(string) (string, []byte)
+	ResponseHandler func(context.Context, string) (string, []byte, error)
 	CustomHandler   func(*chatHistoryModel, string) tea.Cmd // For multi-stage interactions
+	Commands        map[string]chatCommand                  // Handlers for "/command args" input, /help is added
+	Context         *chatContext                            // Conversation whose context usage is shown
 }
 
 type chatHistoryModel struct {
 	viewport viewport.Model
 	textarea textarea.Model
-	messages []struct {
-		role    string
-		content string
-	}
-	ready   bool
-	waiting bool
-	config  ChatHistoryConfig
+	history  *chatTree
+	selected *chatNode // question being edited, chosen with Ctrl+P and Ctrl+N
+	markdown *markdownRenderer
+	search   chatSearch
+	keys     chatKeyMap
+	ready    bool
+	height   int // of the window
+	input    int // textarea height, resized with Alt+↑/↓
+	waiting  bool
+	config   ChatHistoryConfig
+
+	request int                // incremented for each request
+	cancel  context.CancelFunc // cancels the request in flight
+	initCmd tea.Cmd            // responds to the prompt given on the command line
+
+	status   string // shown in place of the help line, see setStatus
+	statusID int
+
+	sending      *chatNode // question waiting for its first answer, removed if the request fails
+	regenerating *chatNode // question whose answer is being regenerated
+	previous     int       // its answer before, shown again if regenerating fails
+	resync       bool      // the context may not match the current branch
 }
 
 func newChatHistoryModel(config ChatHistoryConfig) chatHistoryModel {
@@ -66,42 +98,55 @@ func newChatHistoryModel(config ChatHistoryConfig) chatHistoryModel {
 	ta.Focus()
 	ta.CharLimit = charLimit
 	ta.ShowLineNumbers = false
+	keys := newChatKeyMap(tui().Keys)
+	ta.KeyMap.InsertNewline = keys.Newline
 
 	m := chatHistoryModel{
 		textarea: ta,
+		input:    max(viper.GetInt("chat_inputHeight"), 1),
+		history:  newChatTree(),
+		markdown: newMarkdownRenderer(),
+		search:   newChatSearch(),
+		keys:     keys,
 		config:   config,
 	}
+	if config.Commands != nil {
+		if _, ok := config.Commands["help"]; !ok {
+			config.Commands["help"] = chatCommand{"", "List the commands", helpCommand}
+		}
+	}
 
 	if config.InitialMessage != "" {
-		m.messages = append(m.messages, struct{ role, content string }{"assistant", config.InitialMessage})
+		m.history.add("assistant", config.InitialMessage)
 	}
 
 	if prompt != "" {
-		m.messages = append(m.messages, struct{ role, content string }{"user", prompt})
-		m.waiting = true
+		m.initCmd = m.send(prompt)
 	}
 
 	return m
 }
 
 func initialChatHistoryModel() chatHistoryModel {
-	return newChatHistoryModel(ChatHistoryConfig{
+	return newChatHistoryModel(defaultChatHistoryConfig())
+}
+
+// defaultChatHistoryConfig is the chat TUI's configuration without a persona
+func defaultChatHistoryConfig() ChatHistoryConfig {
+	return ChatHistoryConfig{
 		Title:           "💭 Ponder Chat",
 		Placeholder:     "Enter your message here...",
-		UserLabel:       "You: ",
-		AssistantLabel:  "Ponder:",
-		UserColor:       userColor,
-		AssistantColor:  assistantColor,
+		UserLabel:       cmp.Or(tui().UserLabel, "You: "),
+		AssistantLabel:  cmp.Or(tui().AssistantLabel, "Ponder:"),
 		ResponseHandler: chatResponse,
-	})
+		Context:         ponderContext,
+		Commands:        ponderChatCommands(),
+	}
 }
 
 func (m chatHistoryModel) Init() tea.Cmd {
-	if m.waiting {
-		return tea.Batch(textarea.Blink, func() tea.Msg {
-			response, audio := m.config.ResponseHandler(m.messages[0].content)
-			return responseMsg{content: response, audio: audio}
-		})
+	if m.initCmd != nil {
+		return tea.Batch(textarea.Blink, m.initCmd)
 	}
 	return textarea.Blink
 }
@@ -112,63 +157,168 @@ func (m chatHistoryModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
-		// Layout: title + viewport + textarea + help = msg.Height
-		// viewport height = msg.Height - (titleLines + textareaHeight + helpLines)
-		h := msg.Height - (titleLines + textareaHeight + helpLines)
-		if h < 1 {
-			h = 1
-		}
 		if !m.ready {
-			m.viewport = viewport.New(msg.Width, h)
-			m.textarea.SetWidth(msg.Width - textareaWidth)
-			m.textarea.SetHeight(textareaHeight)
+			m.viewport = viewport.New(msg.Width, 1)
+			m.viewport.KeyMap = chatViewportKeyMap()
+			m.viewport.SetHorizontalStep(horizontalStep)
 			m.ready = true
-		} else {
-			m.viewport.Width, m.viewport.Height = msg.Width, h
-			m.textarea.SetWidth(msg.Width - textareaWidth)
-			m.textarea.SetHeight(textareaHeight)
 		}
+		m.viewport.Width, m.height = msg.Width, msg.Height
+		m.textarea.SetWidth(msg.Width - textareaWidth)
+		m.layout()
 		m.viewport.SetContent(m.renderMessages())
 
 	case responseMsg:
+		if !m.waiting || msg.request != m.request {
+			return m, nil // Response to a cancelled request
+		}
 		m.waiting = false
-		if msg.err != nil {
-			m.messages = append(m.messages, struct{ role, content string }{"system", fmt.Sprintf("Error: %v", msg.err)})
-		} else {
-			m.messages = append(m.messages, struct{ role, content string }{"assistant", msg.content})
+		m.cancel = nil
+		if msg.system {
+			if msg.content != "" {
+				m.addSystemMessage(msg.content)
+			}
+		} else if msg.content != "" || msg.err == nil {
+			m.answered(m.history.leaf())
+			m.history.add("assistant", msg.content)
+			m.regenerating = nil
 			if narrate && msg.audio != nil {
 				go playAudio(msg.audio)
 			}
 		}
+		m.dropQuestion()
+		m.restoreAnswer()
+		for _, note := range msg.notes {
+			m.addSystemMessage(note)
+		}
+		if errors.Is(msg.err, context.DeadlineExceeded) {
+			m.addSystemMessage("⏱️  Request timed out, try again or raise openAI_timeout")
+		} else if msg.err != nil {
+			m.addSystemMessage(fmt.Sprintf("Error: %v", msg.err))
+		}
 		m.viewport.SetContent(m.renderMessages())
 		m.viewport.GotoBottom()
 		return m, nil
 
+	case editorMsg:
+		return m, m.loadEdited(msg)
+
+	case clearStatusMsg:
+		if msg.id == m.statusID {
+			m.status = ""
+		}
+		return m, nil
+
 	case tea.KeyMsg:
-		if msg.Type == tea.KeyCtrlC {
+		if key.Matches(msg, m.keys.Quit) {
+			if m.cancel != nil {
+				m.cancel()
+			}
 			stopAudio()
 			return m, tea.Quit
 		}
+		if m.search.active {
+			return m, m.searchKey(msg)
+		}
+		if key.Matches(msg, m.keys.Search) {
+			return m, m.openSearch()
+		}
 		if m.waiting {
+			if key.Matches(msg, m.keys.Cancel) && m.cancel != nil {
+				m.cancel()
+				m.cancel = nil
+				m.waiting = false
+				m.resync = true // the cancelled request may still change the context
+				m.dropQuestion()
+				m.restoreAnswer()
+				m.addSystemMessage("Request cancelled")
+				m.viewport.SetContent(m.renderMessages())
+				m.viewport.GotoBottom()
+			}
+			return m, nil
+		}
+		if n, ok := m.copyKey(msg); ok {
+			return m, m.copyAnswer(n)
+		}
+		switch {
+		case key.Matches(msg, m.keys.Editor):
+			return m, m.openEditor()
+		case key.Matches(msg, m.keys.GrowInput, m.keys.ShrinkInput):
+			delta := 1
+			if key.Matches(msg, m.keys.ShrinkInput) {
+				delta = -1
+			}
+			m.resizeInput(delta)
+			m.viewport.SetContent(m.renderMessages())
+			return m, nil
+		}
+		if key.Matches(msg, m.keys.Complete) && strings.HasPrefix(m.textarea.Value(), "/") {
+			m.completeCommand()
 			return m, nil
 		}
-		if msg.Type == tea.KeyCtrlD {
+		if m.branching() {
+			handled := true
+			switch {
+			case key.Matches(msg, m.keys.EditPrevious):
+				m.selectQuestion(-1)
+			case key.Matches(msg, m.keys.EditNext):
+				m.selectQuestion(1)
+			case key.Matches(msg, m.keys.SwitchPrevious):
+				m.switchAlternative(-1)
+			case key.Matches(msg, m.keys.SwitchNext):
+				m.switchAlternative(1)
+			case key.Matches(msg, m.keys.Regenerate):
+				cmd = m.regenerate()
+			case key.Matches(msg, m.keys.Cancel):
+				handled = m.selected != nil
+				m.selected = nil
+				m.textarea.Reset()
+			default:
+				handled = false
+			}
+			if handled {
+				m.refresh()
+				return m, cmd
+			}
+		}
+		if key.Matches(msg, m.keys.Send) {
 			if userMsg := strings.TrimSpace(m.textarea.Value()); userMsg != "" {
-				m.messages = append(m.messages, struct{ role, content string }{"user", userMsg})
+				if name, args, ok := parseChatCommand(userMsg); ok && m.config.Commands != nil {
+					command, found := m.config.Commands[name]
+					if found || !strings.Contains(name, "/") { // Paths are sent as messages
+						m.textarea.Reset()
+						if found {
+							cmd = command.Run(&m, args)
+						} else {
+							m.addSystemMessage(fmt.Sprintf("Unknown command /%s, see /help", name))
+						}
+						m.viewport.SetContent(m.renderMessages())
+						m.viewport.GotoBottom()
+						return m, cmd
+					}
+				}
+
 				m.textarea.Reset()
-				m.waiting = true
-				m.viewport.SetContent(m.renderMessages())
-				m.viewport.GotoBottom()
+				if m.selected != nil {
+					cmd = m.editQuestion(userMsg)
+					m.viewport.SetContent(m.renderMessages())
+					m.viewport.GotoBottom()
+					return m, cmd
+				}
 
 				// Use custom handler if provided, otherwise use default response handler
 				if m.config.CustomHandler != nil {
+					m.history.add("user", userMsg)
+					m.waiting = true
+					m.viewport.SetContent(m.renderMessages())
+					m.viewport.GotoBottom()
 					return m, m.config.CustomHandler(&m, userMsg)
 				}
 
-				return m, func() tea.Msg {
-					response, audio := m.config.ResponseHandler(userMsg)
-					return responseMsg{response, audio, nil}
-				}
+				cmd = m.send(userMsg)
+				m.viewport.SetContent(m.renderMessages())
+				m.viewport.GotoBottom()
+				return m, cmd
 			}
 		}
 	}
@@ -183,70 +333,210 @@ func (m chatHistoryModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, tea.Batch(cmds...)
 }
 
+// respond starts a request that can be cancelled with Esc, returning the
+// command that runs it, handlers use this to respond to the user
+func (m *chatHistoryModel) respond(fn func(ctx context.Context) responseMsg) tea.Cmd {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.request++
+	m.cancel = cancel
+	request := m.request
+	return func() tea.Msg {
+		defer cancel()
+		var notes []string
+		msg := fn(context.WithValue(ctx, notesKey{}, &notes))
+		msg.request = request
+		msg.notes = notes
+		return msg
+	}
+}
+
+// send asks the response handler for an answer to a new question
+func (m *chatHistoryModel) send(userMsg string) tea.Cmd {
+	if m.resync {
+		m.syncContext(nil)
+	}
+	m.sending = m.history.add("user", userMsg)
+	m.waiting = true
+	return m.respond(func(ctx context.Context) responseMsg {
+		response, audio, err := m.config.ResponseHandler(ctx, userMsg)
+		return responseMsg{content: response, audio: audio, err: err}
+	})
+}
+
+// addSystemMessage adds an informational message to the history
+func (m *chatHistoryModel) addSystemMessage(content string) {
+	m.history.note(content)
+}
+
+// parseChatCommand splits "/name args" input into the command name and its arguments
+func parseChatCommand(input string) (name, args string, ok bool) {
+	if !strings.HasPrefix(input, "/") {
+		return "", "", false
+	}
+	name, args, _ = strings.Cut(strings.TrimPrefix(input, "/"), " ")
+	return name, strings.TrimSpace(args), name != ""
+}
+
 func (m chatHistoryModel) View() string {
 	if !m.ready {
 		return "\nInitializing..."
 	}
 
-	help := "↑/↓ scroll | Ctrl+D send | Ctrl+C quit"
+	k := m.keys
+	name := func(b key.Binding) string { return b.Help().Key }
+	help := fmt.Sprintf("↑/↓ Ctrl+←/→ scroll | %s send | %s editor | %s search | %s copy | %s quit",
+		name(k.Send), name(k.Editor), name(k.Search), name(k.Copy), name(k.Quit))
+	if m.branching() {
+		help = fmt.Sprintf("↑/↓ Ctrl+←/→ scroll | %s send | %s edit | %s regenerate | %s/%s switch | %s editor | %s search | %s copy | %s quit",
+			name(k.Send), name(k.EditPrevious), name(k.Regenerate), name(k.SwitchPrevious), name(k.SwitchNext), name(k.Editor), name(k.Search), name(k.Copy), name(k.Quit))
+	}
+	if m.selected != nil {
+		help = fmt.Sprintf("✏️  Editing | %s send as a new branch | %s/%s select | %s/%s switch | %s cancel",
+			name(k.Send), name(k.EditPrevious), name(k.EditNext), name(k.SwitchPrevious), name(k.SwitchNext), name(k.Cancel))
+	}
 	if m.waiting {
-		help = "⏳ Waiting... | Ctrl+C quit"
+		help = fmt.Sprintf("⏳ Waiting... | %s cancel | %s quit", name(k.Cancel), name(k.Quit))
+	} else if input := m.textarea.Value(); strings.HasPrefix(input, "/") && !strings.ContainsAny(input, " \n") && m.config.Commands != nil {
+		if matches := m.matchCommands(strings.TrimPrefix(input, "/")); len(matches) > 0 {
+			help = name(k.Complete) + " complete: /" + strings.Join(matches, " /")
+		}
+	} else if m.config.Commands != nil {
+		help += " | /help commands"
+	}
+	if m.status != "" {
+		help = m.status
+	}
+	if usage := sessionUsage(usageSession); usage.InputTokens+usage.OutputTokens > 0 || usage.Cost > 0 {
+		help += fmt.Sprintf(" | 🪙 %d tokens · $%.4f", usage.InputTokens+usage.OutputTokens, usage.Cost)
+	}
+	if m.config.Context != nil {
+		tokens, limit := m.config.Context.usage()
+		help += fmt.Sprintf(" | 📚 %s/%s context", formatTokens(tokens), formatTokens(limit))
 	}
 
 	title := m.config.Title
 	if title == "" {
 		title = "💭 Chat"
 	}
-	titleRendered := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color(titleColor)).Render(title)
-	helpLine := lipgloss.NewStyle().Foreground(lipgloss.Color(helpColor)).Italic(true).Render(help)
+	titleRendered := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color(tui().Colors.Title)).Render(title)
+	helpLine := lipgloss.NewStyle().Foreground(lipgloss.Color(tui().Colors.Help)).Italic(true).Render(help)
+	if m.search.active {
+		helpLine = m.searchStatus()
+	}
 
 	return fmt.Sprintf("%s\n%s\n%s\n%s", titleRendered, m.viewport.View(), m.textarea.View(), helpLine)
 }
 
 func (m chatHistoryModel) renderMessages() string {
-	if len(m.messages) == 0 {
-		return "Start typing below..."
+	content, _ := m.render()
+	if m.search.active {
+		content, _ = highlightMatches(content, m.search.input.Value(), m.search.current)
+	}
+	return content
+}
+
+// render returns the current branch of the history, and the line each message starts on
+func (m chatHistoryModel) render() (string, map[*chatNode]int) {
+	type item struct {
+		role, content string
+		node          *chatNode // nil for system messages
+	}
+	items := []item{}
+	for _, note := range m.history.root.notes {
+		items = append(items, item{"system", note, nil})
+	}
+	for _, node := range m.history.path() {
+		items = append(items, item{node.role, node.content, node})
+		for _, note := range node.notes {
+			items = append(items, item{"system", note, nil})
+		}
+	}
+	if len(items) == 0 {
+		return "Start typing below...", nil
 	}
 
 	var b strings.Builder
+	lines := map[*chatNode]int{}
 	w := m.viewport.Width
 	if w == 0 {
 		w = 80
 	}
 	wrap := lipgloss.NewStyle().Width(w)
 
-	userColorToUse := m.config.UserColor
-	if userColorToUse == "" {
-		userColorToUse = userColor
-	}
-	assistantColorToUse := m.config.AssistantColor
-	if assistantColorToUse == "" {
-		assistantColorToUse = assistantColor
+	colors := tui().Colors
+	userColorToUse := cmp.Or(m.config.UserColor, colors.User)
+	assistantColorToUse := cmp.Or(m.config.AssistantColor, colors.Assistant)
+	if tui().noColor {
+		userColorToUse, assistantColorToUse = "", "" // personas' colors too
 	}
 
-	for i, msg := range m.messages {
+	for i, msg := range items {
 		if i > 0 {
 			b.WriteString("\n")
 		}
+		if msg.node != nil {
+			lines[msg.node] = strings.Count(b.String(), "\n")
+		}
 		switch msg.role {
 		case "user":
 			label := m.config.UserLabel
 			if label == "" {
 				label = "You: "
 			}
-			b.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color(userColorToUse)).Bold(true).Render(label))
+			style := lipgloss.NewStyle().Foreground(lipgloss.Color(userColorToUse)).Bold(true)
+			if msg.node == m.selected {
+				label = "✏️  " + label
+				style = style.Reverse(true)
+			}
+			b.WriteString(style.Render(label) + m.alternatives(msg.node))
 			b.WriteString(wrap.Render(msg.content))
 		case "assistant":
 			label := m.config.AssistantLabel
 			if label == "" {
 				label = "Assistant:"
 			}
-			b.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color(assistantColorToUse)).Bold(true).Render(label) + "\n")
-			b.WriteString(wrap.Render(syntaxHighlightString(msg.content)))
+			b.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color(assistantColorToUse)).Bold(true).Render(label) + m.alternatives(msg.node) + "\n")
+			b.WriteString(m.markdown.render(msg.content, w))
 		case "system":
-			b.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color(systemColor)).Italic(true).Render(wrap.Render(msg.content)))
+			b.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color(colors.System)).Italic(true).Render(wrap.Render(msg.content)))
 		}
 		b.WriteString("\n")
 	}
-	return b.String()
+	return b.String(), lines
+}
+
+// copyKey reports whether msg copies the last answer, Ctrl+Y, or one of its
+// code blocks, Alt+1 to Alt+9 giving the block number n
+func (m chatHistoryModel) copyKey(msg tea.KeyMsg) (n int, ok bool) {
+	if key.Matches(msg, m.keys.Copy) {
+		return 0, true
+	}
+	if msg.Alt && len(msg.Runes) == 1 && msg.Runes[0] >= '1' && msg.Runes[0] <= '9' {
+		return int(msg.Runes[0] - '0'), true
+	}
+	return 0, false
+}
+
+// chatViewportKeyMap scrolls the history with keys that don't type in the
+// textarea, Ctrl+←/→ scrolling wide code blocks
+func chatViewportKeyMap() viewport.KeyMap {
+	keys := viewport.DefaultKeyMap()
+	keys.PageDown = key.NewBinding(key.WithKeys("pgdown"))
+	keys.PageUp = key.NewBinding(key.WithKeys("pgup"))
+	keys.HalfPageDown = key.NewBinding(key.WithDisabled())
+	keys.HalfPageUp = key.NewBinding(key.WithDisabled())
+	keys.Down = key.NewBinding(key.WithKeys("down"))
+	keys.Up = key.NewBinding(key.WithKeys("up"))
+	keys.Left = key.NewBinding(key.WithKeys("ctrl+left"))
+	keys.Right = key.NewBinding(key.WithKeys("ctrl+right"))
+	return keys
+}
+
+// alternatives shows which of a message's alternatives is on the current branch, e.g. ‹2/3›
+func (m chatHistoryModel) alternatives(node *chatNode) string {
+	index, count := node.alternative()
+	if count < 2 {
+		return ""
+	}
+	return lipgloss.NewStyle().Foreground(lipgloss.Color(tui().Colors.Help)).Render(fmt.Sprintf(" ‹%d/%d› ", index+1, count))
 }
@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// chatSearch is a Ctrl+F search of the chat history, typed in place of the help line
+type chatSearch struct {
+	input   textinput.Model
+	active  bool
+	matches []searchMatch
+	current int // match scrolled to, highlighted differently
+}
+
+// searchMatch is where a search term was found, in lines and columns of the rendered history
+type searchMatch struct {
+	line, start, end int
+}
+
+func newChatSearch() chatSearch {
+	input := textinput.New()
+	input.Prompt = "🔍 "
+	input.Placeholder = "Search"
+	return chatSearch{input: input}
+}
+
+// openSearch starts searching, keeping the last search term
+func (m *chatHistoryModel) openSearch() tea.Cmd {
+	m.search.active = true
+	m.textarea.Blur()
+	m.search.input.CursorEnd()
+	m.updateSearch(false)
+	return m.search.input.Focus()
+}
+
+// closeSearch stops searching, removing the highlights
+func (m *chatHistoryModel) closeSearch() {
+	m.search.active = false
+	m.search.matches = nil
+	m.search.input.Blur()
+	m.textarea.Focus()
+	m.viewport.SetXOffset(0)
+	m.viewport.SetContent(m.renderMessages())
+}
+
+// searchKey handles a key while searching, Enter/↓ moving to the next match
+// and ↑ to the previous one
+func (m *chatHistoryModel) searchKey(msg tea.KeyMsg) tea.Cmd {
+	if key.Matches(msg, m.keys.Cancel) {
+		m.closeSearch()
+		return nil
+	}
+	switch msg.String() {
+	case "enter", "down", "ctrl+n":
+		m.moveSearch(1)
+		return nil
+	case "up", "ctrl+p":
+		m.moveSearch(-1)
+		return nil
+	case "pgup", "pgdown":
+		var cmd tea.Cmd
+		m.viewport, cmd = m.viewport.Update(msg)
+		return cmd
+	}
+	query := m.search.input.Value()
+	var cmd tea.Cmd
+	m.search.input, cmd = m.search.input.Update(msg)
+	if m.search.input.Value() != query {
+		m.updateSearch(true)
+	}
+	return cmd
+}
+
+// updateSearch finds the search term in the history, starting from the first
+// match on screen if the term changed
+func (m *chatHistoryModel) updateSearch(changed bool) {
+	content, _ := m.render()
+	_, m.search.matches = highlightMatches(content, m.search.input.Value(), -1)
+	if changed || m.search.current >= len(m.search.matches) {
+		m.search.current = 0
+		for i, match := range m.search.matches {
+			if match.line >= m.viewport.YOffset {
+				m.search.current = i
+				break
+			}
+		}
+	}
+	m.viewport.SetContent(m.renderMessages())
+	m.scrollToMatch()
+}
+
+// moveSearch moves to the next or previous match, wrapping around
+func (m *chatHistoryModel) moveSearch(direction int) {
+	m.updateSearch(false) // the history may have changed
+	if n := len(m.search.matches); n > 0 {
+		m.search.current = (m.search.current + direction + n) % n
+	}
+	m.viewport.SetContent(m.renderMessages())
+	m.scrollToMatch()
+}
+
+// scrollToMatch centers the current match in the viewport, scrolling sideways
+// if it's off screen in a wide code block
+func (m *chatHistoryModel) scrollToMatch() {
+	if m.search.current >= len(m.search.matches) {
+		return
+	}
+	match := m.search.matches[m.search.current]
+	m.viewport.SetYOffset(match.line - m.viewport.Height/2)
+	if match.end > m.viewport.Width {
+		m.viewport.SetXOffset(match.end - m.viewport.Width/2)
+	} else {
+		m.viewport.SetXOffset(0)
+	}
+}
+
+// searchStatus shows the search term and the position of the current match
+func (m chatHistoryModel) searchStatus() string {
+	position := "no matches"
+	if m.search.input.Value() == "" {
+		position = "type to search"
+	} else if len(m.search.matches) > 0 {
+		position = fmt.Sprintf("%d/%d", m.search.current+1, len(m.search.matches))
+	}
+	return fmt.Sprintf("%s %s", m.search.input.View(), lipgloss.NewStyle().Foreground(lipgloss.Color(tui().Colors.Help)).Italic(true).Render(position+" | Enter/↓ next | ↑ prev | "+m.keys.Cancel.Help().Key+" close"))
+}
+
+// highlightMatches highlights query wherever it appears in the rendered
+// content, ignoring case and styling, the current match standing out.
+// It returns the highlighted content and the matches
+func highlightMatches(content, query string, current int) (string, []searchMatch) {
+	if query == "" {
+		return content, nil
+	}
+	query = strings.ToLower(query)
+	matchStyle := lipgloss.NewStyle().Reverse(true)
+	currentStyle := lipgloss.NewStyle().Reverse(true).Bold(true).Underline(true) // NO_COLOR
+	if color := tui().Colors.Match; color != "" {
+		currentStyle = lipgloss.NewStyle().Background(lipgloss.Color(color)).Foreground(lipgloss.Color("0"))
+	}
+
+	var matches []searchMatch
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		plain := strings.ToLower(ansi.Strip(line))
+		var ranges []lipgloss.Range
+		for offset := 0; ; {
+			index := strings.Index(plain[offset:], query)
+			if index < 0 {
+				break
+			}
+			start := ansi.StringWidth(plain[:offset+index])
+			end := start + ansi.StringWidth(query)
+			style := matchStyle
+			if len(matches) == current {
+				style = currentStyle
+			}
+			matches = append(matches, searchMatch{i, start, end})
+			ranges = append(ranges, lipgloss.NewRange(start, end, style))
+			offset += index + len(query)
+		}
+		if len(ranges) > 0 {
+			lines[i] = lipgloss.StyleRanges(line, ranges...)
+		}
+	}
+	return strings.Join(lines, "\n"), matches
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/charmbracelet/x/ansi"
+)
+
+func TestHighlightMatches(t *testing.T) {
+	tests := []struct {
+		name, content, query string
+		want                 []searchMatch
+	}{
+		{"no query", "hello", "", nil},
+		{"no match", "hello", "moon", nil},
+		{"one match", "hello world", "world", []searchMatch{{0, 6, 11}}},
+		{"ignores case", "Hello hello", "HELLO", []searchMatch{{0, 0, 5}, {0, 6, 11}}},
+		{"several lines", "a cat\nno\ncat cat", "cat", []searchMatch{{0, 2, 5}, {2, 0, 3}, {2, 4, 7}}},
+		{"ignores styling", "\x1b[1mbold\x1b[0m text", "bold text", []searchMatch{{0, 0, 9}}},
+		{"wide characters", "日本語 text", "text", []searchMatch{{0, 7, 11}}},
+		{"doesn't overlap", "aaaa", "aa", []searchMatch{{0, 0, 2}, {0, 2, 4}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			highlighted, matches := highlightMatches(tt.content, tt.query, 0)
+			if !reflect.DeepEqual(matches, tt.want) {
+				t.Errorf("highlightMatches() matches = %v, want %v", matches, tt.want)
+			}
+			if ansi.Strip(highlighted) != ansi.Strip(tt.content) {
+				t.Errorf("highlightMatches() changed the text to %q", ansi.Strip(highlighted))
+			}
+		})
+	}
+}
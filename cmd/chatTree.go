@@ -0,0 +1,290 @@
+package cmd
+
+import (
+	"context"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/openai/openai-go/v3"
+)
+
+// chatNode is a message in the chat history. Editing a question or
+// regenerating an answer adds a sibling, so earlier versions are kept as
+// alternatives to switch between
+type chatNode struct {
+	role     string // user or assistant, empty for the root
+	content  string
+	message  *openai.ChatCompletionMessageParamUnion // as the model got it, with any images, nil until answered
+	notes    []string                                // system messages shown after this message
+	parent   *chatNode
+	children []*chatNode
+	active   int // child on the current branch, len(children) while a new one is pending
+}
+
+// chatTree is the history of a chat, the messages shown are the current
+// branch from the root, following each message's active child
+type chatTree struct {
+	root *chatNode
+}
+
+func newChatTree() *chatTree {
+	return &chatTree{root: &chatNode{}}
+}
+
+// add appends a reply to n and makes it the current branch
+func (n *chatNode) add(role, content string) *chatNode {
+	child := &chatNode{role: role, content: content, parent: n}
+	n.children = append(n.children, child)
+	n.active = len(n.children) - 1
+	return child
+}
+
+// activeChild returns the child on the current branch, or nil
+func (n *chatNode) activeChild() *chatNode {
+	if n.active < 0 || n.active >= len(n.children) {
+		return nil
+	}
+	return n.children[n.active]
+}
+
+// alternative returns n's position among its siblings and how many there are
+func (n *chatNode) alternative() (index, count int) {
+	if n.parent == nil {
+		return 0, 1
+	}
+	for i, sibling := range n.parent.children {
+		if sibling == n {
+			return i, len(n.parent.children)
+		}
+	}
+	return 0, len(n.parent.children)
+}
+
+// switchAlternative makes the sibling delta places from n the current branch, returning it
+func (n *chatNode) switchAlternative(delta int) *chatNode {
+	index, count := n.alternative()
+	if n.parent == nil || index+delta < 0 || index+delta >= count {
+		return n
+	}
+	n.parent.active = index + delta
+	return n.parent.children[n.parent.active]
+}
+
+// remove detaches n and its replies from the tree, showing the previous alternative if there is one
+func (n *chatNode) remove() {
+	if n.parent == nil {
+		return
+	}
+	index, _ := n.alternative()
+	parent := n.parent
+	parent.children = append(parent.children[:index], parent.children[index+1:]...)
+	parent.active = max(index-1, 0)
+	n.parent = nil
+}
+
+// path returns the messages on the current branch, oldest first
+func (t *chatTree) path() []*chatNode {
+	var path []*chatNode
+	for n := t.root.activeChild(); n != nil; n = n.activeChild() {
+		path = append(path, n)
+	}
+	return path
+}
+
+// leaf returns the last message on the current branch, or the root if there are none
+func (t *chatTree) leaf() *chatNode {
+	n := t.root
+	for child := n.activeChild(); child != nil; child = n.activeChild() {
+		n = child
+	}
+	return n
+}
+
+// add appends a message to the current branch
+func (t *chatTree) add(role, content string) *chatNode {
+	return t.leaf().add(role, content)
+}
+
+// note adds a system message after the last message on the current branch
+func (t *chatTree) note(content string) {
+	leaf := t.leaf()
+	leaf.notes = append(leaf.notes, content)
+}
+
+// last returns the last message on the current branch with role, or nil
+func (t *chatTree) last(role string) *chatNode {
+	path := t.path()
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i].role == role {
+			return path[i]
+		}
+	}
+	return nil
+}
+
+// branching reports whether questions can be edited and answers regenerated,
+// which needs the response handler to answer any question on its own
+func (m *chatHistoryModel) branching() bool {
+	return m.config.CustomHandler == nil && m.config.ResponseHandler != nil
+}
+
+// selectQuestion selects the question before or after the selected one,
+// putting it in the textarea to edit
+func (m *chatHistoryModel) selectQuestion(direction int) {
+	var questions []*chatNode
+	selected := -1
+	for _, node := range m.history.path() {
+		if node.role == "user" {
+			if node == m.selected {
+				selected = len(questions)
+			}
+			questions = append(questions, node)
+		}
+	}
+	if selected < 0 {
+		selected = len(questions) // nothing selected, start from the end
+	}
+	next := selected + direction
+	if next < 0 || len(questions) == 0 {
+		return
+	}
+	if next >= len(questions) {
+		m.selected = nil
+		m.textarea.Reset()
+		return
+	}
+	m.selected = questions[next]
+	m.textarea.SetValue(m.selected.content)
+}
+
+// switchAlternative shows another version of the selected question, or of the last answer
+func (m *chatHistoryModel) switchAlternative(delta int) {
+	node := m.selected
+	if node == nil {
+		if node = m.history.last("assistant"); node == nil {
+			return
+		}
+	}
+	if switched := node.switchAlternative(delta); switched != node {
+		if m.selected != nil {
+			m.selected = switched
+			m.textarea.SetValue(switched.content)
+		}
+		m.syncContext(nil)
+	}
+}
+
+// editQuestion sends an edited version of the selected question as a new
+// branch, keeping the original and its answers as an alternative
+func (m *chatHistoryModel) editQuestion(userMsg string) tea.Cmd {
+	selected := m.selected
+	m.selected = nil
+	m.syncContext(selected)
+	m.sending = selected.parent.add("user", userMsg)
+	return m.resend(userMsg, selected.images())
+}
+
+// regenerate asks the last question again, keeping the answer as an alternative
+func (m *chatHistoryModel) regenerate() tea.Cmd {
+	question := m.history.last("user")
+	if question == nil {
+		m.addSystemMessage("Nothing to regenerate")
+		return nil
+	}
+	m.selected = nil
+	m.textarea.Reset()
+	m.syncContext(question)
+	m.regenerating, m.previous = question, question.active
+	question.active = len(question.children) // hide the answers until the new one arrives
+	return m.resend(question.content, question.images())
+}
+
+// resend asks the response handler to answer a question again, with the
+// images it was first sent with instead of any pending ones
+func (m *chatHistoryModel) resend(userMsg string, images []string) tea.Cmd {
+	m.waiting = true
+	return m.respond(func(ctx context.Context) responseMsg {
+		response, audio, err := m.config.ResponseHandler(context.WithValue(ctx, imagesKey{}, images), userMsg)
+		return responseMsg{content: response, audio: audio, err: err}
+	})
+}
+
+// images returns the images a question was sent with
+func (n *chatNode) images() []string {
+	if n.message == nil {
+		return nil
+	}
+	return messageImages(*n.message)
+}
+
+// answered records the message the model got for the question being
+// answered, as the handler sent it to the context
+func (m *chatHistoryModel) answered(question *chatNode) {
+	m.sending = nil
+	if question.role == "user" && m.branching() && m.config.Context != nil {
+		question.message = m.config.Context.lastUserMessage()
+	}
+}
+
+// dropQuestion removes the question just sent if it wasn't answered, putting
+// it back in the textarea to send again
+func (m *chatHistoryModel) dropQuestion() {
+	question := m.sending
+	m.sending = nil
+	if question == nil || len(question.children) > 0 {
+		return
+	}
+	if _, count := question.alternative(); count > 1 {
+		m.resync = true // the context was rebuilt for the edited question
+	}
+	question.remove()
+	if m.textarea.Value() == "" {
+		m.textarea.SetValue(question.content)
+	}
+}
+
+// restoreAnswer shows the previous answer again if regenerating failed or was cancelled
+func (m *chatHistoryModel) restoreAnswer() {
+	if m.regenerating == nil {
+		return
+	}
+	m.regenerating.active = m.previous
+	m.regenerating = nil
+	m.resync = true
+}
+
+// syncContext rebuilds the conversation sent to the model from the current
+// branch, stopping before stop if it's on it
+func (m *chatHistoryModel) syncContext(stop *chatNode) {
+	m.resync = false
+	if m.config.Context == nil {
+		return
+	}
+	var messages []openai.ChatCompletionMessageParamUnion
+	for _, node := range m.history.path() {
+		if node == stop {
+			break
+		}
+		switch node.role {
+		case "user":
+			if node.message != nil {
+				messages = append(messages, *node.message)
+			} else {
+				messages = append(messages, openai.UserMessage(node.content))
+			}
+		case "assistant":
+			messages = append(messages, openai.AssistantMessage(node.content))
+		}
+	}
+	m.config.Context.setMessages(messages)
+}
+
+// refresh redraws the history, scrolled to the selected question or the bottom
+func (m *chatHistoryModel) refresh() {
+	content, lines := m.render()
+	m.viewport.SetContent(content)
+	if m.selected != nil {
+		m.viewport.SetYOffset(lines[m.selected])
+	} else {
+		m.viewport.GotoBottom()
+	}
+}
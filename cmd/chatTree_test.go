@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/bubbles/textarea"
+)
+
+// pathText joins the contents of the messages on the current branch
+func pathText(tree *chatTree) string {
+	var contents []string
+	for _, node := range tree.path() {
+		contents = append(contents, node.content)
+	}
+	return strings.Join(contents, " ")
+}
+
+func TestChatTree(t *testing.T) {
+	tests := []struct {
+		name string
+		ops  func(tree *chatTree)
+		want string
+	}{
+		{"empty", func(tree *chatTree) {}, ""},
+		{"add", func(tree *chatTree) {
+			tree.add("user", "q1")
+			tree.add("assistant", "a1")
+		}, "q1 a1"},
+		{"edit adds an alternative", func(tree *chatTree) {
+			q1 := tree.add("user", "q1")
+			tree.add("assistant", "a1")
+			q1.parent.add("user", "q2")
+			tree.add("assistant", "a2")
+		}, "q2 a2"},
+		{"switch to the previous alternative", func(tree *chatTree) {
+			q1 := tree.add("user", "q1")
+			tree.add("assistant", "a1")
+			q2 := q1.parent.add("user", "q2")
+			q2.switchAlternative(-1)
+		}, "q1 a1"},
+		{"switch past the last alternative", func(tree *chatTree) {
+			q1 := tree.add("user", "q1")
+			q1.parent.add("user", "q2").switchAlternative(1)
+		}, "q2"},
+		{"regenerate keeps the answers", func(tree *chatTree) {
+			tree.add("user", "q1")
+			tree.add("assistant", "a1")
+			tree.last("user").add("assistant", "a2")
+		}, "q1 a2"},
+		{"remove shows the previous alternative", func(tree *chatTree) {
+			q1 := tree.add("user", "q1")
+			tree.add("assistant", "a1")
+			q1.parent.add("user", "q2").remove()
+		}, "q1 a1"},
+		{"remove the only message", func(tree *chatTree) {
+			tree.add("user", "q1").remove()
+		}, ""},
+		{"remove the root", func(tree *chatTree) {
+			tree.add("user", "q1")
+			tree.root.remove()
+		}, "q1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := newChatTree()
+			tt.ops(tree)
+			if got := pathText(tree); got != tt.want {
+				t.Errorf("path = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChatNodeAlternative(t *testing.T) {
+	tree := newChatTree()
+	q1 := tree.add("user", "q1")
+	q2 := q1.parent.add("user", "q2")
+	q3 := q1.parent.add("user", "q3")
+	tests := []struct {
+		node         *chatNode
+		index, count int
+	}{
+		{tree.root, 0, 1},
+		{q1, 0, 3},
+		{q2, 1, 3},
+		{q3, 2, 3},
+	}
+	for _, tt := range tests {
+		if index, count := tt.node.alternative(); index != tt.index || count != tt.count {
+			t.Errorf("%q alternative() = %d, %d, want %d, %d", tt.node.content, index, count, tt.index, tt.count)
+		}
+	}
+}
+
+func TestChatTreeLast(t *testing.T) {
+	tree := newChatTree()
+	if tree.last("user") != nil || tree.leaf() != tree.root {
+		t.Fatal("empty tree has messages")
+	}
+	tree.add("user", "q1")
+	tree.add("assistant", "a1")
+	tree.add("user", "q2")
+	if got := tree.last("user").content; got != "q2" {
+		t.Errorf(`last("user") = %q, want "q2"`, got)
+	}
+	if got := tree.last("assistant").content; got != "a1" {
+		t.Errorf(`last("assistant") = %q, want "a1"`, got)
+	}
+}
+
+func TestSyncContextKeepsImages(t *testing.T) {
+	image := "data:image/png;base64,iVBORw0KGgo="
+	tests := []struct {
+		name    string
+		message bool
+		want    []string
+	}{
+		{"sent with an image", true, []string{image}},
+		{"not answered yet", false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := chatHistoryModel{history: newChatTree(), config: ChatHistoryConfig{Context: newChatContext("chat", "", "")}}
+			question := m.history.add("user", "what's this?")
+			if tt.message {
+				message := userMessage("what's this?", []string{image})
+				question.message = &message
+			}
+			m.history.add("assistant", "a cat")
+			m.syncContext(nil)
+			history := m.config.Context.history()
+			if len(history) != 2 {
+				t.Fatalf("context has %d messages, want 2", len(history))
+			}
+			if got := messageImages(history[0]); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("images = %v, want %v", got, tt.want)
+			}
+			if got := question.images(); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("question images = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDropQuestion(t *testing.T) {
+	tests := []struct {
+		name       string
+		send       func(tree *chatTree) *chatNode
+		wantPath   string
+		wantInput  string
+		wantResync bool
+	}{
+		{"failed send", func(tree *chatTree) *chatNode {
+			tree.add("user", "q1")
+			tree.add("assistant", "a1")
+			return tree.add("user", "q2")
+		}, "q1 a1", "q2", false},
+		{"failed edit", func(tree *chatTree) *chatNode {
+			q1 := tree.add("user", "q1")
+			tree.add("assistant", "a1")
+			return q1.parent.add("user", "q1 edited")
+		}, "q1 a1", "q1 edited", true},
+		{"first question", func(tree *chatTree) *chatNode {
+			return tree.add("user", "q1")
+		}, "", "q1", false},
+		{"answered", func(tree *chatTree) *chatNode {
+			q1 := tree.add("user", "q1")
+			tree.add("assistant", "a1")
+			return q1
+		}, "q1 a1", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := chatHistoryModel{history: newChatTree(), textarea: textarea.New()}
+			m.sending = tt.send(m.history)
+			m.dropQuestion()
+			if got := pathText(m.history); got != tt.wantPath {
+				t.Errorf("path = %q, want %q", got, tt.wantPath)
+			}
+			if got := m.textarea.Value(); got != tt.wantInput {
+				t.Errorf("textarea = %q, want %q", got, tt.wantInput)
+			}
+			if m.resync != tt.wantResync {
+				t.Errorf("resync = %v, want %v", m.resync, tt.wantResync)
+			}
+			if m.sending != nil {
+				t.Error("sending wasn't cleared")
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atotto/clipboard"
+	"github.com/aymanbagabas/go-osc52/v2"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// How long a status such as "Copied" replaces the help line
+const statusDuration = 3 * time.Second
+
+// clearStatusMsg clears the status it was sent for, unless a newer one replaced it
+type clearStatusMsg struct{ id int }
+
+// copyToClipboard copies text to the system clipboard, or asks the terminal
+// to with OSC52 over SSH or where there's no system clipboard, returning
+// which was used
+func copyToClipboard(text string) (string, error) {
+	if os.Getenv("SSH_TTY") == "" && os.Getenv("SSH_CONNECTION") == "" && !clipboard.Unsupported {
+		if err := clipboard.WriteAll(text); err == nil {
+			return "clipboard", nil
+		}
+	}
+	seq := osc52.New(text)
+	if os.Getenv("TMUX") != "" {
+		seq = seq.Tmux()
+	} else if strings.HasPrefix(os.Getenv("TERM"), "screen") {
+		seq = seq.Screen()
+	}
+	// Stderr, as stdout belongs to the TUI's renderer
+	if _, err := seq.WriteTo(os.Stderr); err != nil {
+		return "", err
+	}
+	return "terminal (OSC52)", nil
+}
+
+// codeBlocks returns the contents of the fenced code blocks in markdown
+func codeBlocks(markdown string) []string {
+	var blocks []string
+	for _, block := range splitCodeBlocks(markdown) {
+		if !block.code {
+			continue
+		}
+		lines := strings.Split(block.text, "\n")[1:] // the opening fence
+		if n := len(lines); n > 0 && strings.Trim(strings.TrimSpace(lines[n-1]), "`~") == "" {
+			lines = lines[:n-1] // the closing fence
+		}
+		blocks = append(blocks, strings.Join(lines, "\n"))
+	}
+	return blocks
+}
+
+// copyAnswer copies the last answer, or its nth code block counting from 1
+// if n > 0, showing the outcome in the help line
+func (m *chatHistoryModel) copyAnswer(n int) tea.Cmd {
+	last := m.history.last("assistant")
+	if last == nil {
+		return m.setStatus("Nothing to copy yet")
+	}
+	text, what := last.content, "the last answer"
+	if n > 0 {
+		blocks := codeBlocks(last.content)
+		if n > len(blocks) {
+			return m.setStatus(fmt.Sprintf("❌ The last answer has %d code blocks", len(blocks)))
+		}
+		text, what = blocks[n-1], fmt.Sprintf("code block %d", n)
+	}
+	target, err := copyToClipboard(text)
+	if err != nil {
+		return m.setStatus("❌ Couldn't copy: " + err.Error())
+	}
+	return m.setStatus(fmt.Sprintf("📋 Copied %s to the %s", what, target))
+}
+
+// setStatus shows status in place of the help line for statusDuration
+func (m *chatHistoryModel) setStatus(status string) tea.Cmd {
+	m.status = status
+	m.statusID++
+	id := m.statusID
+	return tea.Tick(statusDuration, func(time.Time) tea.Msg {
+		return clearStatusMsg{id}
+	})
+}
+
+// copyCommand handles "/copy [n]", copying the last answer or its nth code block
+func copyCommand(m *chatHistoryModel, args string) tea.Cmd {
+	n := 0
+	if args != "" {
+		var err error
+		if n, err = strconv.Atoi(args); err != nil || n < 1 {
+			m.addSystemMessage("Usage: /copy [code block number]")
+			return nil
+		}
+	}
+	return m.copyAnswer(n)
+}
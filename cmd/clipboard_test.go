@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCodeBlocks(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		want     []string
+	}{
+		{"no code", "Just prose", nil},
+		{"one block", "Run:\n```sh\nls -la\n```\nDone", []string{"ls -la"}},
+		{"numbered in order", "```go\na := 1\n```\ntext\n~~~py\nb = 2\n~~~", []string{"a := 1", "b = 2"}},
+		{"multiline", "```\nline 1\n\nline 3\n```", []string{"line 1\n\nline 3"}},
+		{"nested fences kept", "````md\n```go\nx\n```\n````", []string{"```go\nx\n```"}},
+		{"indented", "  ```\n  ls\n  ```", []string{"  ls"}},
+		{"empty block", "```\n```", []string{""}},
+		{"unclosed", "```go\nfunc main() {\n}", []string{"func main() {\n}"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := codeBlocks(tt.markdown); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("codeBlocks() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
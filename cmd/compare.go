@@ -0,0 +1,374 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/openai/openai-go/v3"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	compareModels   []string
+	compareHeadless bool
+)
+
+// compareResult is one model's answer to the compared prompt
+type compareResult struct {
+	Model        string
+	Content      string
+	Latency      time.Duration
+	InputTokens  int64
+	OutputTokens int64
+	Cost         float64
+	Err          error
+	done         bool
+}
+
+// compareResultMsg delivers a model's result to the comparison TUI
+type compareResultMsg struct {
+	index  int
+	result compareResult
+}
+
+// compareTickMsg updates the elapsed time of the models still answering
+type compareTickMsg struct{}
+
+var compareCmd = &cobra.Command{
+	Use:   "compare --models <model,model,...> <prompt>",
+	Short: "Compare the answers of several models side by side",
+	Long: `Send the same prompt to several models at once and compare their answers, latency, token counts and cost side by side.
+	The prompt can also be piped on stdin. With --headless, or when the output isn't a terminal, a Markdown table is printed instead.
+	`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(compareModels) < 2 {
+			catchErr(errors.New("compare needs at least two models, e.g. --models gpt-4o,gpt-4o-mini"), "fatal")
+		}
+		stdin, err := readStdin()
+		catchErr(err, "fatal")
+		prompt := strings.TrimSpace(strings.TrimSpace(strings.Join(args, " ")) + "\n\n" + stdin)
+		if prompt == "" {
+			catchErr(errors.New("no prompt given, pass one as an argument or on stdin"), "fatal")
+		}
+		_, err = checkBudget("compare", "")
+		catchErr(err, "fatal")
+
+		if compareHeadless || !stdoutIsTerminal() {
+			fmt.Print(compareTable(compareAll(cmd.Context(), compareModels, prompt)))
+			return
+		}
+		p := tea.NewProgram(newCompareModel(cmd.Context(), compareModels, prompt), tea.WithAltScreen(), tea.WithMouseCellMotion())
+		_, err = p.Run()
+		catchErr(err, "fatal")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(compareCmd)
+	compareCmd.Flags().StringSliceVar(&compareModels, "models", nil, "Comma separated models to compare")
+	compareCmd.Flags().BoolVar(&compareHeadless, "headless", false, "Print a Markdown table instead of the side-by-side TUI")
+}
+
+// stdoutIsTerminal reports whether the output is shown in a terminal rather than piped
+func stdoutIsTerminal() bool {
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// compareCompletion asks one model the prompt, timing it and recording its usage
+func compareCompletion(ctx context.Context, model, prompt string) compareResult {
+	result := compareResult{Model: model, done: true}
+	params := openai.ChatCompletionNewParams{
+		Model: model,
+		Messages: []openai.ChatCompletionMessageParamUnion{
+			openai.DeveloperMessage(viper.GetString("openAI_chat_systemMessage")),
+			openai.UserMessage(prompt),
+		},
+	}
+	if chatSettings.temperature != nil {
+		params.Temperature = openai.Float(*chatSettings.temperature)
+	}
+
+	ctx, cancel := openaiContext(ctx)
+	defer cancel()
+	start := time.Now()
+	res, err := ai.Chat.Completions.New(ctx, params)
+	result.Latency = time.Since(start)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	recordChatUsage("compare", usageSession, "", "", res)
+	if len(res.Choices) > 0 {
+		result.Content = res.Choices[0].Message.Content
+	}
+	result.InputTokens, result.OutputTokens = res.Usage.PromptTokens, res.Usage.CompletionTokens
+	result.Cost = usageCost(usageRecord{Model: res.Model, InputTokens: res.Usage.PromptTokens, OutputTokens: res.Usage.CompletionTokens})
+	return result
+}
+
+// compareAll asks every model the prompt concurrently, returning the results in the models' order
+func compareAll(ctx context.Context, models []string, prompt string) []compareResult {
+	results := make([]compareResult, len(models))
+	var wg sync.WaitGroup
+	for i, model := range models {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = compareCompletion(ctx, model, prompt)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// compareTable formats the results as a Markdown table, one row per model
+func compareTable(results []compareResult) string {
+	cell := func(s string) string {
+		s = strings.ReplaceAll(strings.TrimSpace(s), "|", `\|`)
+		return strings.ReplaceAll(s, "\n", "<br>")
+	}
+	var b strings.Builder
+	b.WriteString("| Model | Latency | Input Tokens | Output Tokens | Cost | Response |\n")
+	b.WriteString("|---|---:|---:|---:|---:|---|\n")
+	for _, r := range results {
+		response := r.Content
+		if r.Err != nil {
+			response = "❌ " + r.Err.Error()
+		}
+		fmt.Fprintf(&b, "| %s | %.2fs | %d | %d | $%.4f | %s |\n",
+			cell(r.Model), r.Latency.Seconds(), r.InputTokens, r.OutputTokens, r.Cost, cell(response))
+	}
+	return b.String()
+}
+
+// compareModel is the side-by-side comparison TUI, a pane per model
+type compareModel struct {
+	prompt   string
+	results  []compareResult
+	panes    []viewport.Model
+	focused  int
+	start    time.Time
+	width    int
+	height   int
+	ready    bool
+	markdown *markdownRenderer
+	keys     chatKeyMap
+	status   string
+	statusID int
+	cancel   context.CancelFunc
+	requests []tea.Cmd
+}
+
+func newCompareModel(ctx context.Context, models []string, prompt string) compareModel {
+	ctx, cancel := context.WithCancel(ctx)
+	m := compareModel{
+		prompt:   prompt,
+		results:  make([]compareResult, len(models)),
+		panes:    make([]viewport.Model, len(models)),
+		start:    time.Now(),
+		markdown: newMarkdownRenderer(),
+		keys:     newChatKeyMap(tui().Keys),
+		cancel:   cancel,
+	}
+	for i, model := range models {
+		m.results[i].Model = model
+		m.requests = append(m.requests, func() tea.Msg {
+			return compareResultMsg{i, compareCompletion(ctx, model, prompt)}
+		})
+	}
+	return m
+}
+
+func compareTick() tea.Cmd {
+	return tea.Tick(100*time.Millisecond, func(time.Time) tea.Msg { return compareTickMsg{} })
+}
+
+func (m compareModel) Init() tea.Cmd {
+	return tea.Batch(append(m.requests, compareTick())...)
+}
+
+func (m compareModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height, m.ready = msg.Width, msg.Height, true
+		m.layout()
+
+	case compareResultMsg:
+		m.results[msg.index] = msg.result
+		m.refresh(msg.index)
+
+	case compareTickMsg:
+		if m.pending() == 0 {
+			return m, nil
+		}
+		return m, compareTick()
+
+	case clearStatusMsg:
+		if msg.id == m.statusID {
+			m.status = ""
+		}
+
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, m.keys.Quit), msg.String() == "q":
+			m.cancel()
+			return m, tea.Quit
+		case msg.String() == "tab":
+			m.focused = (m.focused + 1) % len(m.panes)
+		case msg.String() == "shift+tab":
+			m.focused = (m.focused - 1 + len(m.panes)) % len(m.panes)
+		case key.Matches(msg, m.keys.Copy):
+			return m, m.copyFocused()
+		default:
+			var cmd tea.Cmd
+			m.panes[m.focused], cmd = m.panes[m.focused].Update(msg)
+			return m, cmd
+		}
+
+	case tea.MouseMsg:
+		// Scroll the pane under the pointer
+		if m.ready && m.width > 0 {
+			i := min(msg.X*len(m.panes)/m.width, len(m.panes)-1)
+			var cmd tea.Cmd
+			m.panes[i], cmd = m.panes[i].Update(msg)
+			return m, cmd
+		}
+	}
+	return m, nil
+}
+
+// pending returns how many models haven't answered yet
+func (m compareModel) pending() int {
+	n := 0
+	for _, r := range m.results {
+		if !r.done {
+			n++
+		}
+	}
+	return n
+}
+
+// paneSize is the size of a pane's content, inside its border and header
+func (m compareModel) paneSize() (width, height int) {
+	width = max(m.width/len(m.panes)-2, 10)
+	height = max(m.height-titleLines-helpLines-2-1, 1)
+	return width, height
+}
+
+// layout sizes the panes to split the window
+func (m *compareModel) layout() {
+	width, height := m.paneSize()
+	for i := range m.panes {
+		if m.panes[i].Width == 0 {
+			m.panes[i] = viewport.New(width, height)
+			m.panes[i].KeyMap = compareViewportKeyMap()
+			m.panes[i].SetHorizontalStep(horizontalStep)
+		}
+		m.panes[i].Width, m.panes[i].Height = width, height
+		m.refresh(i)
+	}
+}
+
+// compareViewportKeyMap scrolls the focused pane like the chat history, and
+// with ←/→ too as there's no textarea to move the cursor in
+func compareViewportKeyMap() viewport.KeyMap {
+	keys := chatViewportKeyMap()
+	keys.Left = key.NewBinding(key.WithKeys("left", "ctrl+left"))
+	keys.Right = key.NewBinding(key.WithKeys("right", "ctrl+right"))
+	return keys
+}
+
+// refresh renders a model's answer in its pane
+func (m *compareModel) refresh(i int) {
+	if !m.ready {
+		return
+	}
+	r := m.results[i]
+	switch {
+	case r.Err != nil:
+		m.panes[i].SetContent(lipgloss.NewStyle().Width(m.panes[i].Width).Render("❌ " + r.Err.Error()))
+	case r.done:
+		m.panes[i].SetContent(m.markdown.render(r.Content, m.panes[i].Width))
+	}
+}
+
+// copyFocused copies the focused model's answer
+func (m *compareModel) copyFocused() tea.Cmd {
+	r := m.results[m.focused]
+	if !r.done || r.Err != nil {
+		return m.setStatus("Nothing to copy yet")
+	}
+	target, err := copyToClipboard(r.Content)
+	if err != nil {
+		return m.setStatus("❌ Couldn't copy: " + err.Error())
+	}
+	return m.setStatus(fmt.Sprintf("📋 Copied %s's answer to the %s", r.Model, target))
+}
+
+// setStatus shows status in place of the help line for statusDuration
+func (m *compareModel) setStatus(status string) tea.Cmd {
+	m.status = status
+	m.statusID++
+	id := m.statusID
+	return tea.Tick(statusDuration, func(time.Time) tea.Msg {
+		return clearStatusMsg{id}
+	})
+}
+
+// header shows a model's name, latency, tokens and cost, or how long it's been answering
+func (m compareModel) header(r compareResult) string {
+	switch {
+	case r.Err != nil:
+		return fmt.Sprintf("%s · failed after %.1fs", r.Model, r.Latency.Seconds())
+	case r.done:
+		return fmt.Sprintf("%s · %.1fs · %s→%s tokens · $%.4f", r.Model, r.Latency.Seconds(),
+			formatTokens(int(r.InputTokens)), formatTokens(int(r.OutputTokens)), r.Cost)
+	default:
+		return fmt.Sprintf("%s · ⏳ %.1fs", r.Model, time.Since(m.start).Seconds())
+	}
+}
+
+func (m compareModel) View() string {
+	if !m.ready {
+		return "\nInitializing..."
+	}
+	colors := tui().Colors
+	width, _ := m.paneSize()
+
+	var panes []string
+	for i, pane := range m.panes {
+		border := lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).BorderForeground(lipgloss.Color(colors.Help))
+		header := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color(colors.Assistant))
+		if i == m.focused {
+			border = border.BorderForeground(lipgloss.Color(colors.Title))
+			header = header.Reverse(true)
+		}
+		title := header.Render(truncate(m.header(m.results[i]), width))
+		panes = append(panes, border.Render(lipgloss.JoinVertical(lipgloss.Left, title, pane.View())))
+	}
+
+	prompt := truncate(strings.Join(strings.Fields(m.prompt), " "), max(m.width-4, 10))
+	titleRendered := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color(colors.Title)).Render("⚖️  " + prompt)
+
+	help := fmt.Sprintf("Tab/Shift+Tab focus | ↑/↓ ←/→ scroll | %s copy | q quit", m.keys.Copy.Help().Key)
+	if pending := m.pending(); pending > 0 {
+		help = fmt.Sprintf("⏳ Waiting for %d of %d models | ", pending, len(m.results)) + help
+	}
+	if m.status != "" {
+		help = m.status
+	}
+	helpLine := lipgloss.NewStyle().Foreground(lipgloss.Color(colors.Help)).Italic(true).Render(help)
+
+	return fmt.Sprintf("%s\n%s\n%s", titleRendered, lipgloss.JoinHorizontal(lipgloss.Top, panes...), helpLine)
+}
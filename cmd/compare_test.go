@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestCompareKeys(t *testing.T) {
+	tests := []struct {
+		name        string
+		keys        []tea.KeyMsg
+		wantFocused int
+		wantOffset  int // of the first pane
+	}{
+		{"tab", []tea.KeyMsg{{Type: tea.KeyTab}}, 1, 0},
+		{"tab wraps", []tea.KeyMsg{{Type: tea.KeyTab}, {Type: tea.KeyTab}}, 0, 0},
+		{"shift+tab wraps", []tea.KeyMsg{{Type: tea.KeyShiftTab}}, 1, 0},
+		{"right scrolls", []tea.KeyMsg{{Type: tea.KeyRight}}, 0, horizontalStep},
+		{"left scrolls back", []tea.KeyMsg{{Type: tea.KeyRight}, {Type: tea.KeyRight}, {Type: tea.KeyLeft}}, 0, horizontalStep},
+		{"ctrl+right scrolls", []tea.KeyMsg{{Type: tea.KeyCtrlRight}}, 0, horizontalStep},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newCompareModel(context.Background(), []string{"a", "b"}, "prompt")
+			defer m.cancel()
+			model, _ := m.Update(tea.WindowSizeMsg{Width: 40, Height: 20})
+			m = model.(compareModel)
+			content := strings.Repeat("0123456789", 20) // wider than the pane
+			m.panes[0].SetContent(content)
+			for _, msg := range tt.keys {
+				model, _ = m.Update(msg)
+				m = model.(compareModel)
+			}
+			if m.focused != tt.wantFocused {
+				t.Errorf("focused = %d, want %d", m.focused, tt.wantFocused)
+			}
+			if want := content[tt.wantOffset : tt.wantOffset+10]; !strings.HasPrefix(m.panes[0].View(), want) {
+				t.Errorf("first pane shows %q, want it scrolled %d columns", m.panes[0].View()[:10], tt.wantOffset)
+			}
+		})
+	}
+}
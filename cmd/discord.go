@@ -9,6 +9,7 @@ import (
 )
 
 var removeCMDIds string
+var httpAddress string
 
 // discordCmd represents the discord command
 var discordCmd = &cobra.Command{
@@ -16,11 +17,13 @@ var discordCmd = &cobra.Command{
 	Short: "Discord Chat Bot Integration",
 	Long:  `Discord Chat Bot Integration utilizing Secure Gateway Websocket`,
 	Run: func(cmd *cobra.Command, args []string) {
-		initDiscord()
+		gracefulShutdown.Store(true)
+		catchErr(initDiscord(cmd.Context()), "fatal")
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(discordCmd)
 	discordCmd.Flags().StringVarP(&removeCMDIds, "deregister-commands", "D", "", "A comma separated list of command IDs to deregister")
+	discordCmd.Flags().StringVar(&httpAddress, "http-address", "", "Address to serve /healthz, /readyz and /metrics on, e.g. :8080 (default discord_httpAddress)")
 }
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// editorMsg is the prompt written in $EDITOR, sent when the editor exits
+type editorMsg struct {
+	text string
+	err  error
+}
+
+// editorCommand runs $VISUAL or $EDITOR, which may include arguments such as
+// "code --wait", on path, falling back to vi or notepad
+func editorCommand(path string) *exec.Cmd {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	args := strings.Fields(editor)
+	if len(args) == 0 {
+		args = []string{"vi"}
+		if runtime.GOOS == "windows" {
+			args = []string{"notepad"}
+		}
+	}
+	return exec.Command(args[0], append(args[1:], path)...)
+}
+
+// openEditor suspends the TUI to edit the textarea's content in $EDITOR,
+// the edited text replacing it when the editor exits
+func (m *chatHistoryModel) openEditor() tea.Cmd {
+	file, err := os.CreateTemp("", "ponder-*.md")
+	if err != nil {
+		return m.setStatus("❌ " + err.Error())
+	}
+	path := file.Name()
+	_, err = file.WriteString(m.textarea.Value())
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return m.setStatus("❌ " + err.Error())
+	}
+	return tea.ExecProcess(editorCommand(path), func(err error) tea.Msg {
+		defer os.Remove(path)
+		if err != nil {
+			return editorMsg{err: fmt.Errorf("editor failed: %w", err)}
+		}
+		data, err := os.ReadFile(path)
+		return editorMsg{text: strings.TrimRight(string(data), "\n"), err: err}
+	})
+}
+
+// loadEdited puts the text written in the editor in the textarea, growing it
+// to fit up to half the window
+func (m *chatHistoryModel) loadEdited(msg editorMsg) tea.Cmd {
+	if msg.err != nil {
+		return m.setStatus("❌ " + msg.err.Error())
+	}
+	if len([]rune(msg.text)) > m.textarea.CharLimit {
+		return m.setStatus(fmt.Sprintf("❌ The prompt is over %d characters, it was not loaded", m.textarea.CharLimit))
+	}
+	m.textarea.SetValue(msg.text)
+	if lines := strings.Count(msg.text, "\n") + 1; lines > m.input {
+		m.resizeInput(lines - m.input)
+	}
+	return m.setStatus("📝 Loaded the prompt from the editor, " + m.keys.Send.Help().Key + " to send")
+}
+
+// resizeInput grows or shrinks the textarea by delta lines, keeping at least
+// one line and leaving at least half the window for the history
+func (m *chatHistoryModel) resizeInput(delta int) {
+	m.input = max(min(m.input+delta, m.height/2), 1)
+	m.layout()
+}
+
+// layout splits the window between the title, history, textarea and help line
+func (m *chatHistoryModel) layout() {
+	m.textarea.SetHeight(m.input)
+	m.viewport.Height = max(m.height-(titleLines+m.textarea.Height()+helpLines), 1)
+}
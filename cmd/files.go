@@ -0,0 +1,236 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/openai/openai-go/v3"
+	ignore "github.com/sabhiram/go-gitignore"
+	"github.com/spf13/viper"
+)
+
+// Files and directories passed with --file
+var contextFilePaths []string
+
+// gitignore is a parsed .gitignore and the directory its patterns are relative to
+type gitignore struct {
+	dir    string
+	ignore *ignore.GitIgnore
+}
+
+func init() {
+	chatCmd.Flags().StringArrayVarP(&contextFilePaths, "file", "f", nil, "File or directory to add to the chat context, skipping files in .gitignore (repeatable)")
+	rootCmd.Flags().StringArrayVarP(&contextFilePaths, "file", "f", nil, "File or directory to add to the chat context, skipping files in .gitignore (repeatable)")
+}
+
+// addContextFiles attaches a file, or the files in a directory that aren't
+// ignored by .gitignore, to the conversation, returning a note of what was
+// added and a warning if the files take up much of the context window
+func addContextFiles(c *chatContext, path string) (string, error) {
+	path = filepath.Clean(expandHome(path))
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	paths := []string{path}
+	if info.IsDir() {
+		if paths, err = listFiles(path); err != nil {
+			return "", err
+		}
+		if maxFiles := viper.GetInt("files_maxFiles"); maxFiles > 0 && len(paths) > maxFiles {
+			return "", fmt.Errorf("%s has %d files, more than files_maxFiles (%d), add a subdirectory instead", path, len(paths), maxFiles)
+		}
+	}
+
+	var added, skipped []string
+	tokens := 0
+	for _, file := range paths {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		if maxSize := viper.GetInt("files_maxFileSize"); (maxSize > 0 && len(data) > maxSize) || !utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0 {
+			skipped = append(skipped, file) // too large or binary
+			continue
+		}
+		message := openai.UserMessage(fileContent(file, string(data)))
+		c.attachFile(file, message)
+		added = append(added, file)
+		tokens += estimateTokens([]openai.ChatCompletionMessageParamUnion{message})
+	}
+	if len(added) == 0 {
+		return "", fmt.Errorf("no text files to add in %s", path)
+	}
+
+	note := fmt.Sprintf("📄 Added %s (~%s tokens)", added[0], formatTokens(tokens))
+	if len(added) > 1 {
+		note = fmt.Sprintf("📄 Added %d files from %s (~%s tokens)", len(added), path, formatTokens(tokens))
+	}
+	if len(skipped) > 0 {
+		note += fmt.Sprintf(", skipped %d large or binary files", len(skipped))
+	}
+	if warning := contextFilesWarning(c); warning != "" {
+		note += "\n" + warning
+	}
+	return note, nil
+}
+
+// contextFilesWarning warns when the attached files are over files_warnTokens,
+// as they're sent with every message
+func contextFilesWarning(c *chatContext) string {
+	_, tokens := c.attachedFiles()
+	total := 0
+	for _, t := range tokens {
+		total += t
+	}
+	if total <= viper.GetInt("files_warnTokens") {
+		return ""
+	}
+	model := chatModel(c.history())
+	limit := modelContextLimit(model)
+	return fmt.Sprintf("⚠️  Attached files are ~%s tokens, %d%% of %s's context, and are sent with every message. Remove files with /remove",
+		formatTokens(total), total*100/max(limit, 1), model)
+}
+
+// listFiles returns the files under dir, skipping those ignored by the
+// .gitignore files in it and in its parents up to the repository root
+func listFiles(dir string) ([]string, error) {
+	ignores := parentGitignores(dir)
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" || (path != dir && gitignored(ignores, path, true)) {
+				return filepath.SkipDir
+			}
+			if gi, err := ignore.CompileIgnoreFile(filepath.Join(path, ".gitignore")); err == nil {
+				ignores = append(ignores, gitignore{path, gi})
+			}
+			return nil
+		}
+		if d.Type().IsRegular() && !gitignored(ignores, path, false) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// parentGitignores loads the .gitignore files above dir, stopping at the repository root
+func parentGitignores(dir string) []gitignore {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil
+	}
+	var ignores []gitignore
+	for current := abs; ; {
+		if _, err := os.Stat(filepath.Join(current, ".git")); err == nil {
+			break
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			break
+		}
+		current = parent
+		if gi, err := ignore.CompileIgnoreFile(filepath.Join(current, ".gitignore")); err == nil {
+			// Patterns are matched against paths relative to dir as it was given
+			rel, err := filepath.Rel(abs, current)
+			if err != nil {
+				continue
+			}
+			ignores = append(ignores, gitignore{filepath.Join(dir, rel), gi})
+		}
+	}
+	return ignores
+}
+
+// gitignored reports whether any .gitignore applying to path ignores it
+func gitignored(ignores []gitignore, path string, isDir bool) bool {
+	for _, gi := range ignores {
+		rel, err := filepath.Rel(gi.dir, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue // not under this .gitignore's directory
+		}
+		if isDir {
+			rel += "/"
+		}
+		if gi.ignore.MatchesPath(filepath.ToSlash(rel)) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileContent formats a file for the model with its path as a header, fenced
+// with more backticks than any run in the file
+func fileContent(path, content string) string {
+	fence := "```"
+	for strings.Contains(content, fence) {
+		fence += "`"
+	}
+	lang := strings.TrimPrefix(filepath.Ext(path), ".")
+	return fmt.Sprintf("File: %s\n%s%s\n%s\n%s", filepath.ToSlash(path), fence, lang, strings.TrimRight(content, "\n"), fence)
+}
+
+// addCommand handles "/add <path>" in the chat TUI
+func addCommand(m *chatHistoryModel, args string) tea.Cmd {
+	path := strings.TrimSpace(args)
+	if path == "" {
+		m.addSystemMessage("Usage: /add <file or directory>")
+		return nil
+	}
+	note, err := addContextFiles(m.config.Context, path)
+	if err != nil {
+		m.addSystemMessage("❌ " + err.Error())
+		return nil
+	}
+	m.addSystemMessage(note)
+	return nil
+}
+
+// filesCommand handles "/files" in the chat TUI, listing the attached files
+func filesCommand(m *chatHistoryModel, args string) tea.Cmd {
+	paths, tokens := m.config.Context.attachedFiles()
+	if len(paths) == 0 {
+		m.addSystemMessage("No files attached, add one with /add <path>")
+		return nil
+	}
+	var b strings.Builder
+	total := 0
+	for i, path := range paths {
+		fmt.Fprintf(&b, "📄 %s (~%s tokens)\n", path, formatTokens(tokens[i]))
+		total += tokens[i]
+	}
+	fmt.Fprintf(&b, "%d files, ~%s tokens. Remove with /remove <path> or /remove all", len(paths), formatTokens(total))
+	m.addSystemMessage(b.String())
+	return nil
+}
+
+// removeCommand handles "/remove <path|all>" in the chat TUI
+func removeCommand(m *chatHistoryModel, args string) tea.Cmd {
+	path := strings.TrimSpace(args)
+	if path == "" {
+		m.addSystemMessage("Usage: /remove <file, directory or all>")
+		return nil
+	}
+	if path == "all" {
+		path = ""
+	} else {
+		path = filepath.Clean(expandHome(path))
+	}
+	removed := m.config.Context.detachFiles(path)
+	if len(removed) == 0 {
+		m.addSystemMessage(fmt.Sprintf("❌ %s is not attached, see /files", args))
+		return nil
+	}
+	m.addSystemMessage(fmt.Sprintf("🗑️  Removed %d file(s) from the context", len(removed)))
+	return nil
+}
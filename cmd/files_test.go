@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+
+	ignore "github.com/sabhiram/go-gitignore"
+)
+
+func TestGitignored(t *testing.T) {
+	root := filepath.FromSlash("/repo")
+	ignores := []gitignore{
+		{root, ignore.CompileIgnoreLines("*.log", "build/", "/secret.txt", "!keep.log")},
+		{filepath.Join(root, "web"), ignore.CompileIgnoreLines("node_modules/", "*.map")},
+	}
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"main.go", false, false},
+		{"debug.log", false, true},
+		{"logs/debug.log", false, true},
+		{"keep.log", false, false},
+		{"build", true, true},
+		{"build", false, false}, // a file named like the ignored directory
+		{"src/build", true, true},
+		{"secret.txt", false, true},
+		{"src/secret.txt", false, false}, // anchored to the .gitignore's directory
+		{"web/node_modules", true, true},
+		{"web/app.js.map", false, true},
+		{"app.js.map", false, false}, // outside web's .gitignore
+		{"../other/debug.log", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			path := filepath.Join(root, filepath.FromSlash(tt.path))
+			if got := gitignored(ignores, path, tt.isDir); got != tt.want {
+				t.Errorf("gitignored(%q, %v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileContent(t *testing.T) {
+	tests := []struct {
+		name, path, content, want string
+	}{
+		{"plain", "main.go", "package main\n", "File: main.go\n```go\npackage main\n```"},
+		{"no extension", "Makefile", "all:\n", "File: Makefile\n```\nall:\n```"},
+		{"trailing newlines", "a.txt", "text\n\n\n", "File: a.txt\n```txt\ntext\n```"},
+		{"fenced markdown", "README.md", "```sh\nls\n```\n", "File: README.md\n````md\n```sh\nls\n```\n````"},
+		{"longer fences", "doc.md", "````\n```\n````\n", "File: doc.md\n`````md\n````\n```\n````\n`````"},
+		{"backticks inline", "a.md", "use `x` or ``y``", "File: a.md\n```md\nuse `x` or ``y``\n```"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fileContent(tt.path, tt.content); got != tt.want {
+				t.Errorf("fileContent() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+	"github.com/spf13/viper"
+)
+
+// How long OpenAI reachability checks are trusted by /readyz, failures are
+// checked again sooner so the pod is ready again soon after OpenAI recovers
+const (
+	openaiReadyTTL   = 30 * time.Second
+	openaiUnreadyTTL = 10 * time.Second
+)
+
+var openaiReady struct {
+	mu      sync.Mutex
+	checked time.Time
+	err     error
+}
+
+// startHTTPServer serves /healthz, /readyz and /metrics for the Discord bot
+func startHTTPServer(address string) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/readyz", handleReadyz)
+	mux.HandleFunc("/metrics", handleMetrics)
+
+	server := &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		log.Println("🩺 Serving Health and Metrics on", address)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Println("Error serving health and metrics:", err)
+		}
+	}()
+	return server
+}
+
+// handleHealthz reports that the process is alive
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReadyz reports whether the Discord gateway is connected and OpenAI is reachable
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if discordCtx.Err() != nil {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if discord == nil || !discord.DataReady {
+		http.Error(w, "discord gateway not connected", http.StatusServiceUnavailable)
+		return
+	}
+	if !viper.GetBool("discord_readyz_checkOpenAI") {
+		fmt.Fprintln(w, "ok")
+		return
+	}
+	if err := checkOpenAI(r.Context()); err != nil {
+		http.Error(w, "openai unreachable: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetrics(w)
+}
+
+// checkOpenAI looks up the chat model to confirm the API is reachable,
+// caching the result so probes don't hammer the API
+func checkOpenAI(ctx context.Context) error {
+	openaiReady.mu.Lock()
+	defer openaiReady.mu.Unlock()
+	ttl := openaiReadyTTL
+	if openaiReady.err != nil {
+		ttl = openaiUnreadyTTL
+	}
+	if !openaiReady.checked.IsZero() && time.Since(openaiReady.checked) < ttl {
+		return openaiReady.err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	_, err := ai.Models.Get(ctx, viper.GetString("openAI_chat_model"))
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		err = nil // the API answered, the model may just not be listed, such as a fine-tune or proxy alias
+	}
+	openaiReady.checked, openaiReady.err = time.Now(), err
+	return err
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/option"
+	"github.com/spf13/viper"
+)
+
+func TestCheckOpenAI(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{"model found", http.StatusOK, false},
+		{"model not found", http.StatusNotFound, false},
+		{"unauthorized", http.StatusUnauthorized, true},
+		{"server error", http.StatusInternalServerError, true},
+	}
+	previous := ai
+	viper.Set("openAI_chat_model", "gpt-4o")
+	defer func() {
+		ai = previous
+		viper.Set("openAI_chat_model", nil)
+	}()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, `{"id":"gpt-4o","object":"model"}`)
+			}))
+			defer srv.Close()
+			ai = openai.NewClient(option.WithBaseURL(srv.URL), option.WithAPIKey("x"), option.WithMaxRetries(0))
+			openaiReady.checked, openaiReady.err = time.Time{}, nil
+
+			for range 2 {
+				if err := checkOpenAI(context.Background()); (err != nil) != tt.wantErr {
+					t.Errorf("checkOpenAI() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			}
+			if requests.Load() != 1 {
+				t.Errorf("checked OpenAI %d times, want the second check cached", requests.Load())
+			}
+
+			openaiReady.checked = time.Now().Add(-openaiReadyTTL)
+			checkOpenAI(context.Background())
+			if requests.Load() != 2 {
+				t.Errorf("checked OpenAI %d times, want the expired check repeated", requests.Load())
+			}
+		})
+	}
+}
@@ -54,3 +54,16 @@ func httpDownloadFile(url string, filePath string) string {
 	catchErr(err)
 	return filePath
 }
+
+// httpGetBytes downloads the body of url into memory
+func httpGetBytes(url string) ([]byte, error) {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status downloading %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -32,7 +32,7 @@ var imageCmd = &cobra.Command{
 		if len(args) > 0 {
 			prompt = args[0]
 		}
-		createImage(prompt)
+		createImage(cmd.Context(), prompt)
 	},
 }
 
@@ -43,19 +43,27 @@ func init() {
 	imageCmd.Flags().IntVarP(&n, "count", "c", 1, "Number of images to generate")
 }
 
-func createImage(prompt string) {
+func createImage(ctx context.Context, prompt string) {
+	warning, err := checkBudget("image", "")
+	if err != nil {
+		fmt.Println("💸", err)
+		return
+	}
+	if warning != "" {
+		fmt.Println("⚠️ ", warning)
+	}
+
 	fmt.Println("🖼  Creating Image...")
-	res, err := ai.Images.Generate(context.Background(), openai.ImageGenerateParams{
-		Prompt: prompt,
-		Model:  openai.ImageModel(viper.GetString("openAI_image_model")),
-		Size:   openai.ImageGenerateParamsSize(viper.GetString("openAI_image_size")),
-		N:      openai.Int(int64(n)),
-	})
+	ctx, cancel := openaiContext(ctx)
+	defer cancel()
+	params := imageParams(prompt, n)
+	res, err := ai.Images.Generate(ctx, params)
 
 	if err != nil {
 		fmt.Println("❌ Error generating image:", err)
 		return
 	}
+	recordImageUsage("image", string(params.Model), string(params.Size), string(params.Quality), usageSession, "", "", res)
 
 	for imgNum, data := range res.Data {
 		url := data.URL
@@ -100,3 +108,36 @@ func createImage(prompt string) {
 		}
 	}
 }
+
+// imageParams generates n images with the configured model, size and quality
+func imageParams(prompt string, n int) openai.ImageGenerateParams {
+	params := openai.ImageGenerateParams{
+		Prompt: prompt,
+		Model:  openai.ImageModel(viper.GetString("openAI_image_model")),
+		Size:   openai.ImageGenerateParamsSize(viper.GetString("openAI_image_size")),
+		N:      openai.Int(int64(n)),
+	}
+	if quality := viper.GetString("openAI_image_quality"); quality != "" {
+		params.Quality = openai.ImageGenerateParamsQuality(quality)
+	}
+	return params
+}
+
+// generateImageURL generates a single image for the chat's /image command, returning its URL
+func generateImageURL(ctx context.Context, prompt string) (string, error) {
+	if _, err := checkBudget("image", ""); err != nil {
+		return "", err
+	}
+	ctx, cancel := openaiContext(ctx)
+	defer cancel()
+	params := imageParams(prompt, 1)
+	res, err := ai.Images.Generate(ctx, params)
+	if err != nil {
+		return "", err
+	}
+	recordImageUsage("image", string(params.Model), string(params.Size), string(params.Quality), usageSession, "", "", res)
+	if len(res.Data) == 0 {
+		return "", fmt.Errorf("no image was returned")
+	}
+	return res.Data[0].URL, nil
+}
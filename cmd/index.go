@@ -0,0 +1,441 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/openai/openai-go/v3"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// kbIndex is a knowledge base of embedded file chunks, stored in index_dir as <name>.json
+type kbIndex struct {
+	Name  string            `json:"name"`
+	Root  string            `json:"root"`  // absolute path of the indexed directory
+	Model string            `json:"model"` // embedding model, changing it re-embeds everything
+	Files map[string]kbFile `json:"files"` // by path relative to Root
+}
+
+// kbFile is an indexed file, re-embedded only when its contents change
+type kbFile struct {
+	Hash    string    `json:"hash"`
+	ModTime time.Time `json:"modTime"`
+	Size    int64     `json:"size"`
+	Chunks  []kbChunk `json:"chunks"`
+}
+
+// kbChunk is a range of lines from a file and its embedding
+type kbChunk struct {
+	Start     int       `json:"start"` // first line, from 1
+	End       int       `json:"end"`   // last line
+	Text      string    `json:"text"`
+	Embedding []float32 `json:"embedding"`
+}
+
+// kbResult is a chunk retrieved for a question
+type kbResult struct {
+	Path  string
+	Chunk kbChunk
+	Score float64
+}
+
+var indexName, kbName string
+
+// Loaded on the first question to the knowledge base
+var kb *kbIndex
+
+var indexCmd = &cobra.Command{
+	Use:   "index <dir>",
+	Short: "Index a directory for chat with --kb",
+	Long: `Index the Markdown, code and text files in a directory for retrieval-augmented chat.
+	Files are split into chunks of lines and embedded with openAI_embedding_model, and the index is stored in index_dir.
+	Running it again only re-embeds files that changed, and drops files that were removed.
+	Chat with the index using "ponder chat --kb <name>", the name defaults to the directory's name.
+	`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := indexName
+		if name == "" {
+			abs, err := filepath.Abs(args[0])
+			catchErr(err, "fatal")
+			name = filepath.Base(abs)
+		}
+		catchErr(buildIndex(cmd.Context(), name, args[0]), "fatal")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(indexCmd)
+	indexCmd.Flags().StringVar(&indexName, "name", "", "Name of the index, defaults to the directory's name")
+	chatCmd.Flags().StringVar(&kbName, "kb", "", "Answer from an index created with \"ponder index\", citing its files")
+	rootCmd.Flags().StringVar(&kbName, "kb", "", "Answer from an index created with \"ponder index\", citing its files")
+}
+
+func indexPath(name string) string {
+	return filepath.Join(expandHome(viper.GetString("index_dir")), name+".json")
+}
+
+// loadIndex reads the named index from index_dir
+func loadIndex(name string) (*kbIndex, error) {
+	data, err := os.ReadFile(indexPath(name))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("index %q not found, create it with: ponder index <dir> --name %s", name, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	index := &kbIndex{}
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("invalid index %q: %w", name, err)
+	}
+	return index, nil
+}
+
+// save writes the index, replacing the file only once it's complete
+func (index *kbIndex) save() error {
+	path := indexPath(index.Name)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// buildIndex creates or updates an index of dir, embedding new and changed
+// files in batches and saving after each batch so an interrupted run keeps
+// its progress
+func buildIndex(ctx context.Context, name, dir string) error {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	index, err := loadIndex(name)
+	if err != nil || index.Root != root || index.Model != viper.GetString("openAI_embedding_model") {
+		index = &kbIndex{Name: name, Root: root, Model: viper.GetString("openAI_embedding_model")}
+	}
+	if index.Files == nil {
+		index.Files = map[string]kbFile{}
+	}
+
+	paths, err := indexFiles(root)
+	if err != nil {
+		return err
+	}
+
+	var changed, unchanged int
+	pending := map[string]kbFile{}
+	pendingChunks := 0
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		if _, err := checkBudget("index", ""); err != nil {
+			return err
+		}
+		if err := embedFiles(ctx, index.Model, pending); err != nil {
+			return err
+		}
+		for path, file := range pending {
+			index.Files[path] = file
+		}
+		changed += len(pending)
+		pending, pendingChunks = map[string]kbFile{}, 0
+		return index.save()
+	}
+
+	for _, path := range paths {
+		info, err := os.Stat(filepath.Join(root, path))
+		if err != nil {
+			return err
+		}
+		existing, ok := index.Files[path]
+		if ok && existing.ModTime.Equal(info.ModTime()) && existing.Size == info.Size() {
+			unchanged++
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(root, path))
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		if ok && existing.Hash == hash {
+			// Touched but not changed, keep the embeddings
+			existing.ModTime, existing.Size = info.ModTime(), info.Size()
+			index.Files[path] = existing
+			unchanged++
+			continue
+		}
+		if !utf8.Valid(data) {
+			delete(index.Files, path) // binary
+			continue
+		}
+
+		file := kbFile{Hash: hash, ModTime: info.ModTime(), Size: info.Size(), Chunks: chunkText(path, string(data))}
+		if verbose > 0 {
+			fmt.Printf("📄 %s: %d chunks\n", path, len(file.Chunks))
+		}
+		pending[path] = file
+		pendingChunks += len(file.Chunks)
+		if pendingChunks >= viper.GetInt("index_batchSize") {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	removed := 0
+	for path := range index.Files {
+		if _, found := slices.BinarySearch(paths, path); !found {
+			delete(index.Files, path)
+			removed++
+		}
+	}
+	if err := index.save(); err != nil {
+		return err
+	}
+
+	chunks := 0
+	for _, file := range index.Files {
+		chunks += len(file.Chunks)
+	}
+	fmt.Printf("📚 Indexed %s as %q: %d files, %d chunks (%d embedded, %d unchanged, %d removed)\n",
+		root, name, len(index.Files), chunks, changed, unchanged, removed)
+	return nil
+}
+
+// indexFiles lists the files under root with an extension in index_extensions,
+// skipping hidden and excluded directories and files over index_maxFileSize
+func indexFiles(root string) ([]string, error) {
+	extensions := viper.GetStringSlice("index_extensions")
+	exclude := viper.GetStringSlice("index_exclude")
+	maxSize := viper.GetInt64("index_maxFileSize")
+
+	var paths []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		if d.IsDir() {
+			if path != root && (strings.HasPrefix(name, ".") || slices.Contains(exclude, name)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !slices.Contains(extensions, strings.ToLower(filepath.Ext(name))) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || !info.Mode().IsRegular() || (maxSize > 0 && info.Size() > maxSize) {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, filepath.ToSlash(rel))
+		return nil
+	})
+	sort.Strings(paths)
+	return paths, err
+}
+
+// chunkText splits a file into chunks of whole lines up to index_chunkSize
+// characters, each starting with up to index_chunkOverlap lines of the one
+// before. Markdown files also start a new chunk at each heading, keeping
+// sections together
+func chunkText(path, text string) []kbChunk {
+	size := max(viper.GetInt("index_chunkSize"), 200)
+	overlap := max(viper.GetInt("index_chunkOverlap"), 0)
+	markdown := slices.Contains([]string{".md", ".markdown", ".mdx"}, strings.ToLower(filepath.Ext(path)))
+	lines := strings.Split(text, "\n")
+
+	var chunks []kbChunk
+	start, length := 0, 0
+	emit := func(end int, carry bool) {
+		// Cite only the lines with text
+		first, last := start, end
+		for first < last && strings.TrimSpace(lines[first]) == "" {
+			first++
+		}
+		for last > first && strings.TrimSpace(lines[last-1]) == "" {
+			last--
+		}
+		if first < last {
+			chunks = append(chunks, kbChunk{Start: first + 1, End: last, Text: strings.Join(lines[first:last], "\n")})
+		}
+		// Carry over the last lines, up to half a chunk, for context
+		start, length = end, 0
+		for carry && start > 0 && start > end-overlap && length+len(lines[start-1]) < size/2 {
+			start--
+			length += len(lines[start]) + 1
+		}
+	}
+	for i, line := range lines {
+		if markdown && strings.HasPrefix(line, "#") && i > start {
+			emit(i, false)
+		} else if length+len(line) > size && i > start {
+			emit(i, true)
+		}
+		length += len(line) + 1
+	}
+	emit(len(lines), false)
+	return chunks
+}
+
+// embedFiles embeds the chunks of files, in requests of up to index_batchSize chunks
+func embedFiles(ctx context.Context, model string, files map[string]kbFile) error {
+	type ref struct {
+		path  string
+		chunk int
+	}
+	var refs []ref
+	var texts []string
+	for path, file := range files {
+		for i, chunk := range file.Chunks {
+			refs = append(refs, ref{path, i})
+			// Naming the file helps match questions about it
+			texts = append(texts, path+"\n\n"+chunk.Text)
+		}
+	}
+
+	batch := max(viper.GetInt("index_batchSize"), 1)
+	for i := 0; i < len(texts); i += batch {
+		end := min(i+batch, len(texts))
+		embeddings, err := embed(ctx, "index", model, texts[i:end])
+		if err != nil {
+			return err
+		}
+		for j, embedding := range embeddings {
+			r := refs[i+j]
+			files[r.path].Chunks[r.chunk].Embedding = embedding
+		}
+	}
+	return nil
+}
+
+// embed returns the embeddings of texts, recording the usage under command
+func embed(ctx context.Context, command, model string, texts []string) ([][]float32, error) {
+	ctx, cancel := openaiContext(ctx)
+	defer cancel()
+	res, err := ai.Embeddings.New(ctx, openai.EmbeddingNewParams{
+		Model: model,
+		Input: openai.EmbeddingNewParamsInputUnion{OfArrayOfStrings: texts},
+	})
+	if err != nil {
+		return nil, err
+	}
+	recordUsage(usageRecord{
+		Command:     command,
+		Model:       model,
+		Session:     usageSession,
+		InputTokens: res.Usage.PromptTokens,
+	})
+
+	embeddings := make([][]float32, len(texts))
+	for _, data := range res.Data {
+		if int(data.Index) >= len(texts) {
+			continue
+		}
+		embedding := make([]float32, len(data.Embedding))
+		for i, v := range data.Embedding {
+			embedding[i] = float32(v)
+		}
+		embeddings[data.Index] = embedding
+	}
+	return embeddings, nil
+}
+
+// search returns the kb_topK chunks most similar to the question
+func (index *kbIndex) search(ctx context.Context, question string) ([]kbResult, error) {
+	embeddings, err := embed(ctx, "chat", index.Model, []string{question})
+	if err != nil {
+		return nil, err
+	}
+	query := embeddings[0]
+
+	var results []kbResult
+	for path, file := range index.Files {
+		for _, chunk := range file.Chunks {
+			results = append(results, kbResult{Path: path, Chunk: chunk, Score: cosineSimilarity(query, chunk.Embedding)})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	return results[:min(len(results), max(viper.GetInt("kb_topK"), 1))], nil
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// kbExcerpts returns the chunks of the knowledge base relevant to the
+// question, labelled with their path and lines for the model to cite, as a
+// message sent with the question but not kept in the conversation
+func kbExcerpts(ctx context.Context, question string) (openai.ChatCompletionMessageParamUnion, error) {
+	if kb == nil {
+		index, err := loadIndex(kbName)
+		if err != nil {
+			return openai.ChatCompletionMessageParamUnion{}, err
+		}
+		kb = index
+	}
+	results, err := kb.search(ctx, question)
+	if err != nil {
+		return openai.ChatCompletionMessageParamUnion{}, err
+	}
+
+	var b strings.Builder
+	b.WriteString(viper.GetString("kb_prompt"))
+	for _, result := range results {
+		fmt.Fprintf(&b, "\n\n[%s]\n%s", kb.citation(result), result.Chunk.Text)
+	}
+	return openai.SystemMessage(b.String()), nil
+}
+
+// citation formats a result as path:start-end, relative to the working directory when it's nearby
+func (index *kbIndex) citation(result kbResult) string {
+	path := filepath.Join(index.Root, filepath.FromSlash(result.Path))
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(rel, "..") {
+			path = rel
+		}
+	}
+	return fmt.Sprintf("%s:%d-%d", path, result.Chunk.Start, result.Chunk.End)
+}
@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/option"
+	"github.com/spf13/viper"
+)
+
+func TestChunkText(t *testing.T) {
+	line := strings.Repeat("x", 60)
+	long := strings.Repeat("y", 300)
+	tests := []struct {
+		name    string
+		path    string
+		text    string
+		overlap int
+		want    [][2]int // start and end line of each chunk
+	}{
+		{"single chunk", "a.txt", "one\ntwo\nthree", 5, [][2]int{{1, 3}}},
+		{"blank lines trimmed", "a.txt", "\n\nfoo\n\n", 5, [][2]int{{3, 3}}},
+		{"empty", "a.txt", "", 5, nil},
+		{"short first line then long line", "a.json", "{\n" + long + "\n}", 5, [][2]int{{1, 1}, {1, 2}, {3, 3}}},
+		{"long first line", "a.json", long + "\nend", 5, [][2]int{{1, 1}, {2, 2}}},
+		{"markdown headings", "a.md", "# A\ntext\n# B\nmore", 5, [][2]int{{1, 2}, {3, 4}}},
+		{"headings in plain text", "a.txt", "# A\ntext\n# B\nmore", 5, [][2]int{{1, 4}}},
+		{"overlap", "a.txt", strings.Repeat(line+"\n", 6) + line, 5, [][2]int{{1, 3}, {3, 5}, {5, 7}}},
+		{"no overlap", "a.txt", strings.Repeat(line+"\n", 6) + line, 0, [][2]int{{1, 3}, {4, 6}, {7, 7}}},
+	}
+	viper.Set("index_chunkSize", 200)
+	defer viper.Set("index_chunkSize", nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("index_chunkOverlap", tt.overlap)
+			defer viper.Set("index_chunkOverlap", nil)
+			chunks := chunkText(tt.path, tt.text)
+			var got [][2]int
+			for _, c := range chunks {
+				got = append(got, [2]int{c.Start, c.End})
+				lines := strings.Split(tt.text, "\n")
+				if want := strings.Join(lines[c.Start-1:c.End], "\n"); c.Text != want {
+					t.Errorf("chunk %d-%d text = %q, want %q", c.Start, c.End, c.Text, want)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("chunkText() spans = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCosineSimilarity(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float32
+		want float64
+	}{
+		{"same", []float32{1, 2, 3}, []float32{1, 2, 3}, 1},
+		{"scaled", []float32{1, 2, 3}, []float32{2, 4, 6}, 1},
+		{"orthogonal", []float32{1, 0}, []float32{0, 1}, 0},
+		{"opposite", []float32{1, 1}, []float32{-1, -1}, -1},
+		{"zero vector", []float32{0, 0}, []float32{1, 1}, 0},
+		{"different lengths", []float32{1, 2}, []float32{1, 2, 3}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cosineSimilarity(tt.a, tt.b); math.Abs(got-tt.want) > 1e-6 {
+				t.Errorf("cosineSimilarity() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChatCompletionSendsExcerptsOnce(t *testing.T) {
+	var sent [][]map[string]any // messages of each chat request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/embeddings") {
+			w.Write([]byte(`{"object":"list","model":"test","data":[{"object":"embedding","index":0,"embedding":[1,0]}],"usage":{"prompt_tokens":1,"total_tokens":1}}`))
+			return
+		}
+		var body struct {
+			Messages []map[string]any `json:"messages"`
+		}
+		catchErr(json.NewDecoder(r.Body).Decode(&body))
+		sent = append(sent, body.Messages)
+		w.Write([]byte(`{"id":"1","object":"chat.completion","model":"test","choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":"answer"}}],"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`))
+	}))
+	defer srv.Close()
+
+	previousAI, previousContext, previousKB := ai, ponderContext, kb
+	ai = openai.NewClient(option.WithBaseURL(srv.URL), option.WithAPIKey("x"))
+	ponderContext = newChatContext("chat", "", "")
+	kbName, kb = "docs", &kbIndex{Name: "docs", Root: t.TempDir(), Files: map[string]kbFile{
+		"deploy.md": {Chunks: []kbChunk{{Start: 1, End: 2, Text: "helm install ponder", Embedding: []float32{1, 0}}}},
+	}}
+	viper.Set("usage_ledgerFile", filepath.Join(t.TempDir(), "usage.jsonl"))
+	viper.Set("context_defaultWindow", 10000)
+	defer func() {
+		ai, ponderContext, kb, kbName = previousAI, previousContext, previousKB, ""
+		viper.Set("usage_ledgerFile", nil)
+		viper.Set("context_defaultWindow", nil)
+	}()
+
+	for _, question := range []string{"How do I deploy?", "And upgrade?"} {
+		if _, err := chatCompletion(context.Background(), question); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		roles []string // of the messages sent
+	}{
+		{"first question", []string{"system", "user"}},
+		{"second question", []string{"user", "assistant", "system", "user"}},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var roles []string
+			for _, message := range sent[i] {
+				roles = append(roles, message["role"].(string))
+			}
+			if !slices.Equal(roles, tt.roles) {
+				t.Fatalf("sent roles %v, want %v", roles, tt.roles)
+			}
+			if excerpts := sent[i][len(roles)-2]["content"].(string); !strings.Contains(excerpts, "helm install ponder") {
+				t.Errorf("excerpts = %q, want the chunk", excerpts)
+			}
+		})
+	}
+	for _, message := range ponderContext.history() {
+		if _, text, _ := messageText(message); strings.Contains(text, "helm install ponder") {
+			t.Errorf("excerpts kept in the conversation: %q", text)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/openai/openai-go/v3"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var jsonSchemaFile string
+var jsonOutput bool
+
+// jsonFlags adds the structured output flags to a command
+func jsonFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&jsonSchemaFile, "json-schema", "", "Respond with JSON matching the JSON Schema in this file, without the TUI")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Respond with a JSON object, without the TUI")
+}
+
+func init() {
+	jsonFlags(chatCmd)
+	jsonFlags(rootCmd)
+}
+
+// jsonRun prints the response to the prompt, and any piped input, as JSON,
+// retrying with the validation error if it doesn't match the schema
+func jsonRun(ctx context.Context, prompt string) error {
+	input, err := readStdin()
+	if err != nil {
+		return err
+	}
+	if input != "" {
+		prompt = strings.TrimSpace(prompt + "\n\n" + input)
+	}
+	if prompt == "" {
+		return fmt.Errorf("no prompt given, pass one as an argument or on stdin")
+	}
+	if _, err := checkBudget("json", ""); err != nil {
+		return err
+	}
+
+	params := openai.ChatCompletionNewParams{
+		Model: viper.GetString("openAI_json_model"),
+		Messages: []openai.ChatCompletionMessageParamUnion{
+			openai.DeveloperMessage(viper.GetString("openAI_json_systemMessage")),
+			userMessage(prompt, pendingImages),
+		},
+	}
+
+	var schema *jsonschema.Schema
+	if jsonSchemaFile != "" {
+		var raw any
+		schema, raw, err = loadJSONSchema(jsonSchemaFile)
+		if err != nil {
+			return err
+		}
+		params.ResponseFormat = openai.ChatCompletionNewParamsResponseFormatUnion{
+			OfJSONSchema: &openai.ResponseFormatJSONSchemaParam{
+				JSONSchema: openai.ResponseFormatJSONSchemaJSONSchemaParam{
+					Name:   jsonSchemaName(jsonSchemaFile),
+					Schema: raw,
+				},
+			},
+		}
+	} else {
+		params.ResponseFormat = openai.ChatCompletionNewParamsResponseFormatUnion{
+			OfJSONObject: &openai.ResponseFormatJSONObjectParam{},
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		content, err := jsonCompletion(ctx, params)
+		if err != nil {
+			return err
+		}
+		err = validateJSON(content, schema)
+		if err == nil {
+			var out bytes.Buffer
+			if err := json.Indent(&out, []byte(content), "", "  "); err != nil {
+				return err
+			}
+			fmt.Println(out.String())
+			return nil
+		}
+		if attempt >= viper.GetInt("openAI_json_retries") {
+			return fmt.Errorf("response failed validation after %d attempts: %w", attempt+1, err)
+		}
+
+		// Show the model its mistake and ask again
+		params.Messages = append(params.Messages,
+			openai.AssistantMessage(content),
+			openai.UserMessage("That response is invalid: "+err.Error()+"\nReply again with only the corrected JSON."),
+		)
+	}
+}
+
+func jsonCompletion(ctx context.Context, params openai.ChatCompletionNewParams) (string, error) {
+	ctx, cancel := openaiContext(ctx)
+	defer cancel()
+	res, err := ai.Chat.Completions.New(ctx, params)
+	if err != nil {
+		return "", err
+	}
+	recordChatUsage("json", usageSession, "", "", res)
+	if refusal := res.Choices[0].Message.Refusal; refusal != "" {
+		return "", fmt.Errorf("model refused: %s", refusal)
+	}
+	return res.Choices[0].Message.Content, nil
+}
+
+// loadJSONSchema compiles a JSON Schema file for validation, also returning it
+// decoded for the response_format sent to the API
+func loadJSONSchema(path string) (*jsonschema.Schema, any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var raw any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, nil, fmt.Errorf("invalid JSON Schema %s: %w", path, err)
+	}
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(path, doc); err != nil {
+		return nil, nil, err
+	}
+	schema, err := compiler.Compile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid JSON Schema %s: %w", path, err)
+	}
+	return schema, raw, nil
+}
+
+// validateJSON checks content is JSON, and matches the schema if there is one
+func validateJSON(content string, schema *jsonschema.Schema) error {
+	value, err := jsonschema.UnmarshalJSON(strings.NewReader(content))
+	if err != nil {
+		return fmt.Errorf("not valid JSON: %w", err)
+	}
+	if schema == nil {
+		return nil
+	}
+	return schema.Validate(value)
+}
+
+// jsonSchemaName names the schema after its file, as the API only allows letters, digits, _ and -
+func jsonSchemaName(path string) string {
+	name := strings.Trim(formatPrompt(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))), "-")
+	if name == "" {
+		return "response"
+	}
+	return name[:min(len(name), 64)]
+}
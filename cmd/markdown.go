@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/glamour"
+	glamouransi "github.com/charmbracelet/glamour/ansi"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/muesli/termenv"
+)
+
+// markdownRenderer renders assistant messages in the chat TUI. Prose is
+// wrapped to the viewport while code blocks are left unwrapped, to scroll
+// horizontally, and rendered messages are cached as the whole history is
+// redrawn on every change
+type markdownRenderer struct {
+	style *glamouransi.StyleConfig // nil if it couldn't be loaded
+	width int
+	prose *glamour.TermRenderer
+	code  *glamour.TermRenderer
+	cache map[string]string
+}
+
+// newMarkdownRenderer uses the tui theme's Markdown and code styles
+func newMarkdownRenderer() *markdownRenderer {
+	style, err := tui().markdownStyle()
+	if err != nil {
+		catchErr(err)
+		return &markdownRenderer{}
+	}
+	return &markdownRenderer{style: &style}
+}
+
+// render renders markdown for a viewport width wide, falling back to the
+// plain text if the style can't be loaded
+func (r *markdownRenderer) render(markdown string, width int) string {
+	if (width != r.width || r.cache == nil) && r.style != nil {
+		r.width = width
+		r.cache = map[string]string{}
+		r.prose = r.renderer(width)
+		r.code = r.renderer(0)
+	}
+	if rendered, ok := r.cache[markdown]; ok {
+		return rendered
+	}
+	if r.prose == nil || r.code == nil {
+		return lipgloss.NewStyle().Width(width).Render(markdown)
+	}
+
+	var parts []string
+	code := 0
+	for _, block := range splitCodeBlocks(markdown) {
+		renderer := r.prose
+		if block.code {
+			renderer = r.code
+			block.text = strings.ReplaceAll(block.text, "\t", "    ") // tabs would throw off the scroll width
+		}
+		rendered, err := renderer.Render(block.text)
+		if err != nil {
+			rendered = block.text
+		}
+		if rendered = trimBlankLines(rendered); rendered == "" {
+			continue
+		}
+		if block.code {
+			code++
+			rendered = codeLabel(code, block.text) + "\n" + rendered
+		}
+		parts = append(parts, rendered)
+	}
+	rendered := strings.Join(parts, "\n\n")
+	r.cache[markdown] = rendered
+	return rendered
+}
+
+// codeLabel numbers a code block, the number copying it with /copy n or Alt+n
+func codeLabel(n int, block string) string {
+	fence, _, _ := strings.Cut(strings.TrimSpace(block), "\n")
+	label := fmt.Sprintf("  [%d]", n)
+	if lang := strings.TrimLeft(fence, "`~"); lang != "" {
+		label += " " + strings.TrimSpace(lang)
+	}
+	return lipgloss.NewStyle().Foreground(lipgloss.Color(tui().Colors.Help)).Render(label)
+}
+
+// renderer renders Markdown wrapped at wrap columns, or not wrapped if it's 0
+func (r *markdownRenderer) renderer(wrap int) *glamour.TermRenderer {
+	options := []glamour.TermRendererOption{glamour.WithStyles(*r.style), glamour.WithWordWrap(wrap)}
+	if tui().noColor {
+		options = append(options, glamour.WithColorProfile(termenv.Ascii))
+	}
+	renderer, err := glamour.NewTermRenderer(options...)
+	if err != nil {
+		return nil
+	}
+	return renderer
+}
+
+type markdownBlock struct {
+	text string
+	code bool // a fenced code block, fences included
+}
+
+// splitCodeBlocks splits markdown into prose and fenced code blocks, an
+// unclosed fence running to the end as it does while an answer streams in
+func splitCodeBlocks(markdown string) []markdownBlock {
+	var blocks []markdownBlock
+	var current []string
+	fence := ""
+	flush := func(code bool) {
+		if text := strings.Join(current, "\n"); strings.TrimSpace(text) != "" {
+			blocks = append(blocks, markdownBlock{text, code})
+		}
+		current = nil
+	}
+	for _, line := range strings.Split(markdown, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case fence == "" && (strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~")):
+			flush(false)
+			fence = trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, trimmed[:1]))]
+			current = append(current, line)
+		case fence != "" && strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "":
+			current = append(current, line)
+			flush(true)
+			fence = ""
+		default:
+			current = append(current, line)
+		}
+	}
+	flush(fence != "")
+	return blocks
+}
+
+// trimBlankLines removes the blank lines glamour puts around a document and
+// the padding at the end of each line
+func trimBlankLines(rendered string) string {
+	lines := strings.Split(rendered, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	for len(lines) > 0 && strings.TrimSpace(ansi.Strip(lines[0])) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(ansi.Strip(lines[len(lines)-1])) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
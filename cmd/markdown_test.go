@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCodeBlocks(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		want     []markdownBlock
+	}{
+		{"prose", "Hello\n\nworld", []markdownBlock{{"Hello\n\nworld", false}}},
+		{"empty", "", nil},
+		{"code only", "```go\nfmt.Println()\n```", []markdownBlock{{"```go\nfmt.Println()\n```", true}}},
+		{"prose around code", "Run:\n```sh\nls\n```\nDone", []markdownBlock{
+			{"Run:", false},
+			{"```sh\nls\n```", true},
+			{"Done", false},
+		}},
+		{"tilde fence", "~~~\ncode\n~~~", []markdownBlock{{"~~~\ncode\n~~~", true}}},
+		{"longer fence holds shorter", "````md\n```go\nx\n```\n````", []markdownBlock{{"````md\n```go\nx\n```\n````", true}}},
+		{"closed by a longer fence", "```\nx\n`````\nafter", []markdownBlock{{"```\nx\n`````", true}, {"after", false}}},
+		{"other fence character doesn't close", "```\n~~~\n```", []markdownBlock{{"```\n~~~\n```", true}}},
+		{"fence with text doesn't close", "```\n```go\n```", []markdownBlock{{"```\n```go\n```", true}}},
+		{"indented fence", "- step\n  ```sh\n  ls\n  ```", []markdownBlock{{"- step", false}, {"  ```sh\n  ls\n  ```", true}}},
+		{"unclosed while streaming", "Here:\n```go\nfunc main() {", []markdownBlock{{"Here:", false}, {"```go\nfunc main() {", true}}},
+		{"adjacent blocks", "```\na\n```\n```\nb\n```", []markdownBlock{{"```\na\n```", true}, {"```\nb\n```", true}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitCodeBlocks(tt.markdown); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitCodeBlocks() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
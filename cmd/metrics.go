@@ -0,0 +1,236 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// counterVec is a Prometheus counter with labels
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64 // by label values joined with labelSeparator
+}
+
+// histogramVec is a Prometheus histogram with labels
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+const labelSeparator = "\xff"
+
+// Metrics served by the /metrics endpoint
+var (
+	metricDiscordMessages = newCounterVec("ponder_discord_messages_total",
+		"Discord messages handled by the bot")
+	metricDiscordCommands = newCounterVec("ponder_discord_commands_total",
+		"Discord slash commands and buttons handled, by name", "command")
+	metricDiscordRejections = newCounterVec("ponder_discord_rejections_total",
+		"Discord requests refused by access control, rate limits or quotas", "reason")
+	metricOpenAIRequests = newCounterVec("ponder_openai_requests_total",
+		"OpenAI API requests, by endpoint and HTTP status", "endpoint", "status")
+	metricOpenAIErrors = newCounterVec("ponder_openai_errors_total",
+		"OpenAI API requests that failed or returned an error status", "endpoint")
+	metricOpenAITokens = newCounterVec("ponder_openai_tokens_total",
+		"OpenAI tokens used, by model and type", "model", "type")
+	metricOpenAILatency = newHistogramVec("ponder_openai_request_duration_seconds",
+		"OpenAI API request latency", []float64{0.25, 0.5, 1, 2.5, 5, 10, 20, 40, 60, 120}, "endpoint")
+
+	metrics = []interface{ writeTo(io.Writer) }{
+		metricDiscordMessages,
+		metricDiscordCommands,
+		metricDiscordRejections,
+		metricOpenAIRequests,
+		metricOpenAIErrors,
+		metricOpenAITokens,
+		metricOpenAILatency,
+	}
+)
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogram{}}
+}
+
+// inc adds one to the counter for the label values
+func (c *counterVec) inc(labelValues ...string) {
+	c.add(1, labelValues...)
+}
+
+// add adds v to the counter for the label values
+func (c *counterVec) add(v float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[strings.Join(labelValues, labelSeparator)] += v
+}
+
+// observe records v in the histogram for the label values
+func (h *histogramVec) observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := strings.Join(labelValues, labelSeparator)
+	series := h.series[key]
+	if series == nil {
+		series = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = series
+	}
+	for i, bucket := range h.buckets {
+		if v <= bucket {
+			series.counts[i]++
+			break
+		}
+	}
+	series.sum += v
+	series.count++
+}
+
+// writeTo writes the counter in the Prometheus text format
+func (c *counterVec) writeTo(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, key, ""), formatFloat(c.values[key]))
+	}
+}
+
+// writeTo writes the histogram in the Prometheus text format
+func (h *histogramVec) writeTo(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.series) {
+		series := h.series[key]
+		var cumulative uint64
+		for i, bucket := range h.buckets {
+			cumulative += series.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, formatFloat(bucket)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, "+Inf"), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, key, ""), formatFloat(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, key, ""), series.count)
+	}
+}
+
+// formatLabels renders {name="value",...}, adding the le label for histogram buckets
+func formatLabels(names []string, key, le string) string {
+	var pairs []string
+	if len(names) > 0 {
+		for i, value := range strings.Split(key, labelSeparator) {
+			pairs = append(pairs, names[i]+"="+strconv.Quote(value))
+		}
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeMetrics writes all metrics in the Prometheus text format
+func writeMetrics(w io.Writer) {
+	for _, metric := range metrics {
+		metric.writeTo(w)
+	}
+}
+
+// openaiMetricsMiddleware records the latency, status and token usage of OpenAI API requests
+func openaiMetricsMiddleware(req *http.Request, next func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	endpoint := openaiEndpoint(req.URL.Path)
+	start := time.Now()
+	res, err := next(req)
+	metricOpenAILatency.observe(time.Since(start).Seconds(), endpoint)
+
+	if err != nil {
+		metricOpenAIRequests.inc(endpoint, "error")
+		metricOpenAIErrors.inc(endpoint)
+		return res, err
+	}
+	metricOpenAIRequests.inc(endpoint, strconv.Itoa(res.StatusCode))
+	if res.StatusCode >= 400 {
+		metricOpenAIErrors.inc(endpoint)
+		return res, err
+	}
+
+	// Peek at the usage of JSON responses, streamed responses are left alone
+	if strings.HasPrefix(res.Header.Get("Content-Type"), "application/json") && endpoint != "models" {
+		body, readErr := io.ReadAll(res.Body)
+		res.Body.Close()
+		res.Body = io.NopCloser(bytes.NewReader(body))
+		if readErr != nil {
+			return res, readErr
+		}
+		var usage struct {
+			Model string `json:"model"`
+			Usage struct {
+				PromptTokens     int64 `json:"prompt_tokens"`
+				CompletionTokens int64 `json:"completion_tokens"`
+				InputTokens      int64 `json:"input_tokens"`
+				OutputTokens     int64 `json:"output_tokens"`
+			} `json:"usage"`
+		}
+		if json.Unmarshal(body, &usage) == nil {
+			if input := usage.Usage.PromptTokens + usage.Usage.InputTokens; input > 0 {
+				metricOpenAITokens.add(float64(input), usage.Model, "input")
+			}
+			if output := usage.Usage.CompletionTokens + usage.Usage.OutputTokens; output > 0 {
+				metricOpenAITokens.add(float64(output), usage.Model, "output")
+			}
+		}
+	}
+	return res, nil
+}
+
+// openaiEndpoint maps a request path to a metrics label, keeping the number of label values small
+func openaiEndpoint(path string) string {
+	for _, endpoint := range []string{
+		"chat/completions", "images/generations", "images/variations", "images/edits",
+		"audio/speech", "audio/transcriptions", "embeddings", "responses", "models",
+	} {
+		if strings.Contains(path, "/"+endpoint) {
+			return endpoint
+		}
+	}
+	return "other"
+}
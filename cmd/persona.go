@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"cmp"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/openai/openai-go/v3"
+	"github.com/spf13/viper"
+)
+
+// persona is a named assistant profile configured in personas, empty fields
+// keep the defaults
+type persona struct {
+	SystemMessage  string   `mapstructure:"systemMessage"`
+	Model          string   `mapstructure:"model"`
+	Temperature    *float64 `mapstructure:"temperature"`
+	Voice          string   `mapstructure:"voice"`
+	Title          string   `mapstructure:"title"`
+	UserLabel      string   `mapstructure:"userLabel"`
+	AssistantLabel string   `mapstructure:"assistantLabel"`
+	UserColor      string   `mapstructure:"userColor"`
+	AssistantColor string   `mapstructure:"assistantColor"`
+}
+
+var personaName string
+
+func init() {
+	chatCmd.Flags().StringVar(&personaName, "persona", "", "Persona to chat with, from the personas config")
+	rootCmd.Flags().StringVar(&personaName, "persona", "", "Persona to chat with, from the personas config")
+}
+
+// loadPersonas returns the configured personas by name
+func loadPersonas() (map[string]persona, error) {
+	var personas map[string]persona
+	if err := viper.UnmarshalKey("personas", &personas); err != nil {
+		return nil, err
+	}
+	return personas, nil
+}
+
+// findPersona looks up a persona by name, ignoring case as config keys are case-insensitive
+func findPersona(name string) (persona, error) {
+	personas, err := loadPersonas()
+	if err != nil {
+		return persona{}, err
+	}
+	p, ok := personas[strings.ToLower(name)]
+	if !ok {
+		return persona{}, fmt.Errorf("persona %q not found, available personas: %s", name, personaNames(personas))
+	}
+	return p, nil
+}
+
+func personaNames(personas map[string]persona) string {
+	names := make([]string, 0, len(personas))
+	for name := range personas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return "none configured"
+	}
+	return strings.Join(names, ", ")
+}
+
+// defaultPersona is the name of the persona without any changes, for going back to the defaults
+const defaultPersona = "default"
+
+// defaultVoice is the --voice flag, captured before a persona changes the voice
+var defaultVoice = sync.OnceValue(func() string { return voice })
+
+// applyChatPersona uses a persona's system prompt, model, temperature and
+// voice for the rest of the chat, empty fields going back to the defaults
+func applyChatPersona(p persona) {
+	ponderContext.setSystemMessage(openai.DeveloperMessage(cmp.Or(p.SystemMessage, viper.GetString("openAI_chat_systemMessage"))))
+	chatSettings.model = p.Model
+	chatSettings.temperature = p.Temperature
+	voice = cmp.Or(p.Voice, defaultVoice())
+}
+
+// applyTo styles the chat TUI with the persona's title, labels and colors,
+// empty fields going back to the defaults
+func (p persona) applyTo(config *ChatHistoryConfig) {
+	defaults := defaultChatHistoryConfig()
+	config.Title = cmp.Or(p.Title, defaults.Title)
+	config.UserLabel = cmp.Or(p.UserLabel, defaults.UserLabel)
+	config.AssistantLabel = cmp.Or(p.AssistantLabel, defaults.AssistantLabel)
+	config.UserColor = cmp.Or(p.UserColor, defaults.UserColor)
+	config.AssistantColor = cmp.Or(p.AssistantColor, defaults.AssistantColor)
+}
+
+// personaCommand handles "/persona <name>" in the chat TUI, switching persona
+// while keeping the conversation
+func personaCommand(m *chatHistoryModel, args string) tea.Cmd {
+	name := strings.TrimSpace(args)
+	if name == "" {
+		personas, err := loadPersonas()
+		if err != nil {
+			m.addSystemMessage("❌ " + err.Error())
+			return nil
+		}
+		m.addSystemMessage("Personas: " + personaNames(personas) + "\nUsage: /persona <name>, /persona " + defaultPersona + " goes back to the defaults")
+		return nil
+	}
+
+	p, err := findPersona(name)
+	if err != nil && strings.EqualFold(name, defaultPersona) {
+		p, err = persona{}, nil // unless one is configured with that name
+	}
+	if err != nil {
+		m.addSystemMessage("❌ " + err.Error())
+		return nil
+	}
+	applyChatPersona(p)
+	p.applyTo(&m.config)
+	m.addSystemMessage(fmt.Sprintf("🎭 Switched to persona %q", name))
+	return nil
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestApplyPersonaResetsDefaults(t *testing.T) {
+	warm := 1.2
+	full := persona{
+		SystemMessage:  "You are a pirate.",
+		Model:          "gpt-pirate",
+		Temperature:    &warm,
+		Voice:          "fable",
+		Title:          "🏴‍☠️ Pirate",
+		UserLabel:      "Matey: ",
+		AssistantLabel: "Captain:",
+		UserColor:      "#ff0000",
+		AssistantColor: "#00ff00",
+	}
+	tests := []struct {
+		name            string
+		next            persona
+		wantSystem      string
+		wantModel       string
+		wantVoice       string
+		wantTitle       string
+		wantLabel       string
+		wantColor       string
+		wantTemperature bool
+	}{
+		{"default", persona{}, "You are helpful.", "", "onyx", "💭 Ponder Chat", "Ponder:", "", false},
+		{"only a system message", persona{SystemMessage: "You review code."}, "You review code.", "", "onyx", "💭 Ponder Chat", "Ponder:", "", false},
+		{"full", full, full.SystemMessage, full.Model, full.Voice, full.Title, full.AssistantLabel, full.AssistantColor, true},
+	}
+	viper.Set("openAI_chat_systemMessage", "You are helpful.")
+	defer viper.Set("openAI_chat_systemMessage", nil)
+	voice = "onyx"
+	defaultVoice()
+	previousContext, previousSettings := ponderContext, chatSettings
+	defer func() { ponderContext, chatSettings, voice = previousContext, previousSettings, "onyx" }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ponderContext = newChatContext("chat", "", "")
+			config := defaultChatHistoryConfig()
+			applyChatPersona(full)
+			full.applyTo(&config)
+			applyChatPersona(tt.next)
+			tt.next.applyTo(&config)
+			if got := ponderContext.systemMessage(); got != tt.wantSystem {
+				t.Errorf("system message = %q, want %q", got, tt.wantSystem)
+			}
+			if chatSettings.model != tt.wantModel {
+				t.Errorf("model = %q, want %q", chatSettings.model, tt.wantModel)
+			}
+			if (chatSettings.temperature != nil) != tt.wantTemperature {
+				t.Errorf("temperature = %v, want set %v", chatSettings.temperature, tt.wantTemperature)
+			}
+			if voice != tt.wantVoice {
+				t.Errorf("voice = %q, want %q", voice, tt.wantVoice)
+			}
+			if config.Title != tt.wantTitle || config.AssistantLabel != tt.wantLabel || config.AssistantColor != tt.wantColor {
+				t.Errorf("config = %q, %q, %q, want %q, %q, %q", config.Title, config.AssistantLabel, config.AssistantColor, tt.wantTitle, tt.wantLabel, tt.wantColor)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenBucket holds the available tokens for a single rate limited key
+type tokenBucket struct {
+	tokens    float64
+	last      time.Time
+	perMinute float64 // rate and burst of the last call, for pruning
+	burst     int
+}
+
+// full reports whether the bucket has refilled to its burst by now
+func (b *tokenBucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Minutes()*b.perMinute >= float64(b.burst)
+}
+
+// How often buckets that have refilled are removed, as they're the same as new ones
+const rateLimiterPruneEvery = time.Minute
+
+// rateLimiter is a keyed token bucket rate limiter, the rate and burst are
+// passed on every call so they can change when the config is reloaded
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	pruned  time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: map[string]*tokenBucket{}}
+}
+
+// allow takes a token from the bucket for key, refilled at perMinute tokens
+// per minute up to burst, a perMinute of zero or less disables the limit
+func (l *rateLimiter) allow(key string, perMinute float64, burst int) bool {
+	if perMinute <= 0 {
+		return true
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.pruned) >= rateLimiterPruneEvery {
+		l.prune(now)
+	}
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(burst), last: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.last).Minutes() * perMinute
+	if bucket.tokens > float64(burst) {
+		bucket.tokens = float64(burst)
+	}
+	bucket.last = now
+	bucket.perMinute, bucket.burst = perMinute, burst
+
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// prune removes the buckets that have refilled, the caller must hold the lock
+func (l *rateLimiter) prune(now time.Time) {
+	for key, bucket := range l.buckets {
+		if bucket.full(now) {
+			delete(l.buckets, key)
+		}
+	}
+	l.pruned = now
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	tests := []struct {
+		name      string
+		perMinute float64
+		burst     int
+		elapsed   time.Duration // since the bucket was last used, before the final calls
+		calls     int
+		want      int // calls allowed
+	}{
+		{"disabled", 0, 1, 0, 10, 10},
+		{"negative rate disables", -1, 1, 0, 10, 10},
+		{"burst", 60, 3, 0, 5, 3},
+		{"burst of zero allows one", 60, 0, 0, 5, 1},
+		{"refills over time", 60, 3, 2 * time.Second, 5, 2},
+		{"refills up to the burst", 60, 3, time.Hour, 5, 3},
+		{"partial token isn't enough", 1, 1, 30 * time.Second, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := newRateLimiter()
+			if tt.elapsed > 0 {
+				for limiter.allow("user", tt.perMinute, tt.burst) { // empty the bucket
+				}
+				limiter.buckets["user"].last = time.Now().Add(-tt.elapsed)
+			}
+			got := 0
+			for range tt.calls {
+				if limiter.allow("user", tt.perMinute, tt.burst) {
+					got++
+				}
+			}
+			if got != tt.want {
+				t.Errorf("allowed %d of %d calls, want %d", got, tt.calls, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiterKeys(t *testing.T) {
+	limiter := newRateLimiter()
+	if !limiter.allow("a", 1, 1) || limiter.allow("a", 1, 1) {
+		t.Fatal("a wasn't limited to its burst")
+	}
+	if !limiter.allow("b", 1, 1) {
+		t.Error("a's limit applied to b")
+	}
+}
+
+func TestRateLimiterPrune(t *testing.T) {
+	tests := []struct {
+		name    string
+		elapsed time.Duration // since the bucket was last used
+		want    bool          // bucket kept
+	}{
+		{"in use", 0, true},
+		{"still refilling", 10 * time.Second, true},
+		{"refilled", time.Minute, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := newRateLimiter()
+			limiter.allow("user", 6, 2)
+			limiter.allow("user", 6, 2)
+			limiter.buckets["user"].last = time.Now().Add(-tt.elapsed)
+			limiter.pruned = time.Now().Add(-rateLimiterPruneEvery)
+			limiter.allow("other", 6, 2)
+			if _, kept := limiter.buckets["user"]; kept != tt.want {
+				t.Errorf("bucket kept = %v, want %v", kept, tt.want)
+			}
+		})
+	}
+}
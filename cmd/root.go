@@ -5,8 +5,10 @@ Copyright © 2023 Kevin Jayne <kevin.jayne@icloud.com>
 */
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"sync/atomic"
 
 	"github.com/openai/openai-go/v3"
 	"github.com/openai/openai-go/v3/option"
@@ -14,7 +16,7 @@ import (
 	"github.com/spf13/viper"
 )
 
-var ponderMessages []openai.ChatCompletionMessageParamUnion
+var ponderContext *chatContext
 var appVersion = "v0.4.3"
 var ai openai.Client
 
@@ -31,6 +33,9 @@ var prompt,
 // Configuration variable for OpenAI user ID
 var openaiUser string
 
+// Set by commands that shut down on their own when the context is cancelled
+var gracefulShutdown atomic.Bool
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "ponder [prompt]",
@@ -55,14 +60,21 @@ var rootCmd = &cobra.Command{
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
-func Execute() {
-	err := rootCmd.Execute()
+// The context is cancelled when the process receives SIGTERM or Ctrl+C.
+func Execute(ctx context.Context) {
+	err := rootCmd.ExecuteContext(ctx)
 	if err != nil {
 		CleanupAndExit()
 		os.Exit(1)
 	}
 }
 
+// GracefulShutdown reports whether the running command handles shutdown itself
+// when the context passed to Execute is cancelled
+func GracefulShutdown() bool {
+	return gracefulShutdown.Load()
+}
+
 // CleanupAndExit performs cleanup before exiting the application
 func CleanupAndExit() {
 	stopAudio()
@@ -76,6 +88,7 @@ func init() {
 	rootCmd.PersistentFlags().CountVarP(&verbose, "verbose", "v", "verbose output (use -v, -vv, -vvv for more)")
 	rootCmd.PersistentFlags().BoolVarP(&narrate, "narrate", "n", false, "Narrate the response using TTS and the default audio output")
 	rootCmd.PersistentFlags().StringVar(&voice, "voice", "onyx", "Voice to use: alloy, ash, coral, echo, fable, onyx, nova, sage and shimmer")
+	rootCmd.Flags().StringArrayVar(&imageFiles, "image", nil, "Image file to send with the prompt to a vision model (repeatable)")
 
 	// Check for Required Environment Variables
 	openaiAPIKey = os.Getenv("OPENAI_API_KEY")
@@ -94,9 +107,17 @@ func viperConfig() {
 	// use spf13/viper to read config file
 
 	viper.SetDefault("openAI_endpoint", "https://api.openai.com/v1/")
+	viper.SetDefault("openAI_timeout", "5m")        // Each call, including retries
+	viper.SetDefault("openAI_requestTimeout", "2m") // Each attempt
+	viper.SetDefault("openAI_maxRetries", 3)
+
+	viper.SetDefault("openAI_json_model", "gpt-4o")
+	viper.SetDefault("openAI_json_retries", 2)
+	viper.SetDefault("openAI_json_systemMessage", "Respond only with JSON, without any other text.")
 
 	viper.SetDefault("openAI_image_model", "dall-e-3")
 	viper.SetDefault("openAI_image_size", "1024x1024")
+	viper.SetDefault("openAI_image_quality", "") // the model's default, e.g. standard or hd for dall-e-3
 	viper.SetDefault("openAI_image_downloadPath", "~/Ponder/Images/")
 
 	viper.SetDefault("openAI_tts_model", "tts-1")
@@ -108,7 +129,10 @@ func viperConfig() {
 	viper.SetDefault("openAI_speed", "1")
 	viper.SetDefault("openAI_responseFormat", "mp3")
 
+	viper.SetDefault("openAI_embedding_model", "text-embedding-3-small")
+
 	viper.SetDefault("openAI_chat_model", "gpt-4")
+	viper.SetDefault("openAI_chat_visionModel", "gpt-4o")
 	viper.SetDefault("openAI_chat_systemMessage", "You are a helpful assistant.")
 
 	viper.SetDefault("openAI_topP", "0.9")
@@ -117,6 +141,115 @@ func viperConfig() {
 	viper.SetDefault("openAI_temperature", "0")
 	viper.SetDefault("openAI_maxTokens", "4096")
 
+	viper.SetDefault("discord_message_context_count", 15)
+	viper.SetDefault("discord_shutdownTimeout", "20s")
+	viper.SetDefault("discord_adventure_turnTimeout", "10m")
+	viper.SetDefault("discord_httpAddress", "")
+	viper.SetDefault("discord_readyz_checkOpenAI", true)
+	viper.SetDefault("discord_bot_responseMode", "mention")
+	viper.SetDefault("discord_bot_directMessages", true)
+	viper.SetDefault("discord_bot_settingsFile", "~/.ponder/discord.json")
+
+	viper.SetDefault("discord_access_deniedMessage", "🚫 Sorry, you don't have access to Ponder here.")
+	viper.SetDefault("discord_rateLimit_userPerMinute", 6)
+	viper.SetDefault("discord_rateLimit_userBurst", 3)
+	viper.SetDefault("discord_rateLimit_guildPerMinute", 30)
+	viper.SetDefault("discord_rateLimit_guildBurst", 10)
+	viper.SetDefault("discord_rateLimit_message", "⏱️ Slow down! Please wait a moment before asking Ponder again.")
+	viper.SetDefault("discord_quota_userDaily", 0) // USD
+	viper.SetDefault("discord_quota_message", "💸 The daily Ponder quota has been used up, please try again tomorrow.")
+
+	// Prices in USD per 1M tokens, per image and per 1M TTS characters
+	viper.SetDefault("usage_ledgerFile", "~/.ponder/usage.jsonl")
+	viper.SetDefault("usage_prices", []map[string]any{
+		{"model": "gpt-4", "input": 30.0, "output": 60.0},
+		{"model": "gpt-4-turbo", "input": 10.0, "output": 30.0},
+		{"model": "gpt-4o", "input": 2.5, "output": 10.0},
+		{"model": "gpt-4o-mini", "input": 0.15, "output": 0.6},
+		{"model": "gpt-4.1", "input": 2.0, "output": 8.0},
+		{"model": "gpt-4.1-mini", "input": 0.4, "output": 1.6},
+		{"model": "gpt-4.1-nano", "input": 0.1, "output": 0.4},
+		{"model": "gpt-3.5-turbo", "input": 0.5, "output": 1.5},
+		{"model": "gpt-image-1", "input": 5.0, "output": 40.0},
+		{"model": "dall-e-3", "image": 0.04, "images": map[string]float64{
+			"1024x1792": 0.08, "1792x1024": 0.08,
+			"hd 1024x1024": 0.08, "hd 1024x1792": 0.12, "hd 1792x1024": 0.12,
+		}},
+		{"model": "dall-e-2", "image": 0.02, "images": map[string]float64{"256x256": 0.016, "512x512": 0.018}},
+		{"model": "tts-1", "characters": 15.0},
+		{"model": "tts-1-hd", "characters": 30.0},
+		{"model": "text-embedding-3-small", "input": 0.02},
+		{"model": "text-embedding-3-large", "input": 0.13},
+		{"model": "text-embedding-ada-002", "input": 0.1},
+	})
+
+	viper.SetDefault("templates_dir", "~/.ponder/templates")
+	viper.SetDefault("chat_sessionsDir", "~/.ponder/sessions")
+	viper.SetDefault("chat_inputHeight", textareaHeight) // lines, resized in the chat with Alt+↑/↓
+
+	// Chat TUI, colors and styles not set come from the theme
+	viper.SetDefault("tui.theme", "auto") // auto, dark, light or high-contrast
+	viper.SetDefault("tui.keys.send", []string{"ctrl+d"})
+	viper.SetDefault("tui.keys.newline", []string{"enter"})
+	viper.SetDefault("tui.keys.quit", []string{"ctrl+c"})
+	viper.SetDefault("tui.keys.cancel", []string{"esc"})
+	viper.SetDefault("tui.keys.complete", []string{"tab"})
+	viper.SetDefault("tui.keys.editor", []string{"ctrl+e"})
+	viper.SetDefault("tui.keys.search", []string{"ctrl+f"})
+	viper.SetDefault("tui.keys.copy", []string{"ctrl+y"})
+	viper.SetDefault("tui.keys.regenerate", []string{"ctrl+r"})
+	viper.SetDefault("tui.keys.editPrevious", []string{"ctrl+p"})
+	viper.SetDefault("tui.keys.editNext", []string{"ctrl+n"})
+	viper.SetDefault("tui.keys.switchPrevious", []string{"shift+left"})
+	viper.SetDefault("tui.keys.switchNext", []string{"shift+right"})
+	viper.SetDefault("tui.keys.growInput", []string{"alt+up"})
+	viper.SetDefault("tui.keys.shrinkInput", []string{"alt+down"})
+
+	// Files attached to the chat with --file and /add
+	viper.SetDefault("files_maxFileSize", 256*1024)
+	viper.SetDefault("files_maxFiles", 200)
+	viper.SetDefault("files_warnTokens", 20000)
+
+	// Knowledge base indexes for chat --kb, chunk sizes are in characters
+	viper.SetDefault("index_dir", "~/.ponder/index")
+	viper.SetDefault("index_extensions", []string{".md", ".markdown", ".mdx", ".txt", ".rst", ".adoc", ".go", ".py", ".js", ".jsx", ".ts", ".tsx", ".java", ".kt", ".rb", ".rs", ".c", ".h", ".cpp", ".hpp", ".cs", ".php", ".swift", ".sh", ".sql", ".yaml", ".yml", ".toml", ".json"})
+	viper.SetDefault("index_exclude", []string{"node_modules", "vendor", "dist", "build", "target"})
+	viper.SetDefault("index_maxFileSize", 1<<20)
+	viper.SetDefault("index_chunkSize", 2000)
+	viper.SetDefault("index_chunkOverlap", 5)
+	viper.SetDefault("index_batchSize", 100)
+	viper.SetDefault("kb_topK", 5)
+	viper.SetDefault("kb_prompt", "Answer the next question using these excerpts. Cite the excerpts you use by their [path:start-end] label. If they don't contain the answer, say so.")
+
+	// Context windows in tokens, models match by longest prefix
+	viper.SetDefault("context_windows", []map[string]any{
+		{"model": "gpt-4", "tokens": 8192},
+		{"model": "gpt-4-turbo", "tokens": 128000},
+		{"model": "gpt-4o", "tokens": 128000},
+		{"model": "gpt-4.1", "tokens": 1047576},
+		{"model": "gpt-5", "tokens": 400000},
+		{"model": "gpt-3.5-turbo", "tokens": 16385},
+		{"model": "o1", "tokens": 200000},
+		{"model": "o3", "tokens": 200000},
+		{"model": "o4-mini", "tokens": 200000},
+	})
+	viper.SetDefault("context_defaultWindow", 8192)
+	viper.SetDefault("context_maxTokens", 0)
+	viper.SetDefault("context_summarizeAt", 0.75)
+	viper.SetDefault("context_keepMessages", 6)
+	viper.SetDefault("context_summaryModel", "gpt-4o-mini")
+	viper.SetDefault("context_summaryPrompt", "Summarize the conversation below so the assistant can continue it. Keep names, facts, decisions, open questions and anything the user asked to remember. Be concise.")
+
+	// Spending limits in USD, zero means no limit
+	viper.SetDefault("budget_daily", 0)
+	viper.SetDefault("budget_monthly", 0)
+	viper.SetDefault("budget_guildDaily", 0)
+	viper.SetDefault("budget_guildMonthly", 0)
+	viper.SetDefault("budget_commands", map[string]any{})
+	viper.SetDefault("budget_guilds", map[string]any{})
+	viper.SetDefault("budget_warnAt", 0.8)
+	viper.SetDefault("budget_message", "💸 Ponder's spending budget has been reached, please try again later.")
+
 	viper.SetDefault("radio_notificationSound", "~/.ponder/audio/notify.mp3")
 
 	viper.SetConfigName("config")        // name of config file (without extension)
@@ -138,15 +271,19 @@ func viperConfig() {
 	}
 
 	systemMessage := viper.GetString("openAI_chat_systemMessage")
-	ponderMessages = []openai.ChatCompletionMessageParamUnion{
-		openai.DeveloperMessage(systemMessage),
-	}
+	ponderContext = newChatContext("chat", usageSession, "", openai.DeveloperMessage(systemMessage))
 
 	// Initialize OpenAI user ID
 	openaiUser = "ponder" + HashAPIKey(openaiAPIKey)
 
 	opts := []option.RequestOption{
 		option.WithAPIKey(openaiAPIKey),
+		option.WithMiddleware(openaiMetricsMiddleware),
+		// Retries 408, 409, 429 and 5xx responses with exponential backoff, honoring Retry-After
+		option.WithMaxRetries(viper.GetInt("openAI_maxRetries")),
+	}
+	if timeout := viper.GetDuration("openAI_requestTimeout"); timeout > 0 {
+		opts = append(opts, option.WithRequestTimeout(timeout))
 	}
 
 	baseURL := viper.GetString("openAI_endpoint")
@@ -156,3 +293,11 @@ func viperConfig() {
 
 	ai = openai.NewClient(opts...)
 }
+
+// openaiContext bounds an OpenAI call, including its retries, by openAI_timeout
+func openaiContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if timeout := viper.GetDuration("openAI_timeout"); timeout > 0 {
+		return context.WithTimeout(parent, timeout)
+	}
+	return context.WithCancel(parent)
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/openai/openai-go/v3"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var templateVars []string
+
+// runCmd represents the run command
+var runCmd = &cobra.Command{
+	Use:   "run <template> [input]",
+	Short: "Run a prompt template",
+	Long: `Run a prompt template from templates_dir, filling its {{.var}} placeholders with --var key=value.
+	Input piped on stdin, or given after the template name, fills {{.input}}, or is appended to the prompt if the template doesn't use it.
+	`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		catchErr(runTemplate(cmd.Context(), args[0], strings.Join(args[1:], " ")), "fatal")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(runCmd)
+	runCmd.Flags().StringArrayVar(&templateVars, "var", nil, "Template variable as key=value (repeatable)")
+}
+
+// runTemplate sends a rendered template and prints the response
+func runTemplate(ctx context.Context, name, input string) error {
+	t, err := loadTemplate(name)
+	if err != nil {
+		return err
+	}
+	vars, err := parseVars(templateVars)
+	if err != nil {
+		return err
+	}
+	stdin, err := readStdin()
+	if err != nil {
+		return err
+	}
+	input = strings.TrimSpace(strings.TrimSpace(input) + "\n\n" + stdin)
+	if input != "" {
+		vars["input"] = input
+	}
+
+	system, prompt, err := t.render(vars)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(t.Prompt, ".input") && input != "" {
+		prompt = strings.TrimSpace(prompt + "\n\n" + input)
+	}
+	if prompt == "" {
+		return fmt.Errorf("template %q has no prompt and no input was given", name)
+	}
+	if _, err := checkBudget("run", ""); err != nil {
+		return err
+	}
+
+	params := openai.ChatCompletionNewParams{
+		Model:    t.Model,
+		Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage(prompt)},
+	}
+	if params.Model == "" {
+		params.Model = viper.GetString("openAI_chat_model")
+	}
+	if system != "" {
+		params.Messages = append([]openai.ChatCompletionMessageParamUnion{openai.DeveloperMessage(system)}, params.Messages...)
+	}
+	if t.Temperature != nil {
+		params.Temperature = openai.Float(*t.Temperature)
+	}
+	if t.MaxTokens > 0 {
+		params.MaxCompletionTokens = openai.Int(t.MaxTokens)
+	}
+
+	ctx, cancel := openaiContext(ctx)
+	defer cancel()
+	res, err := ai.Chat.Completions.New(ctx, params)
+	if err != nil {
+		return err
+	}
+	recordChatUsage("run", usageSession, "", "", res)
+	fmt.Println(res.Choices[0].Message.Content)
+	return nil
+}
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+// Characters of context shown either side of a match by "ponder sessions search"
+const snippetContext = 40
+
+// savedSession is a conversation saved with /save and its name
+type savedSession struct {
+	Name string
+	chatSession
+}
+
+// sessionMatch is a message of a saved conversation containing a search term
+type sessionMatch struct {
+	session savedSession
+	role    string
+	snippet string
+}
+
+var sessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: "List and search saved conversations",
+	Long: `List and search the conversations saved with /save in the chat, stored in chat_sessionsDir (default ~/.ponder/sessions).
+	Load one in the chat with /load <name>.
+	`,
+}
+
+var sessionsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List saved conversations",
+	Run: func(cmd *cobra.Command, args []string) {
+		sessions, err := loadSessions()
+		catchErr(err, "fatal")
+		if len(sessions) == 0 {
+			fmt.Println("No saved conversations, save one in the chat with /save [name]")
+			return
+		}
+		data := pterm.TableData{{"Session", "Time", "Model", "Messages"}}
+		for _, s := range sessions {
+			data = append(data, []string{s.Name, s.Time.Local().Format("2006-01-02 15:04"), s.Model, fmt.Sprint(len(s.Messages))})
+		}
+		catchErr(pterm.DefaultTable.WithHasHeader().WithData(data).Render())
+	},
+}
+
+var sessionsSearchCmd = &cobra.Command{
+	Use:   "search <term>",
+	Short: "Search the messages of saved conversations",
+	Long: `Search the messages of all saved conversations, ignoring case.
+	Each matching message is shown with its session, when it was saved and a snippet of the match.
+	`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		term := strings.Join(args, " ")
+		sessions, err := loadSessions()
+		catchErr(err, "fatal")
+		matches := searchSessions(sessions, term)
+		if len(matches) == 0 {
+			fmt.Printf("No saved conversations mention %q\n", term)
+			return
+		}
+		data := pterm.TableData{{"Session", "Time", "Role", "Snippet"}}
+		for _, match := range matches {
+			data = append(data, []string{match.session.Name, match.session.Time.Local().Format("2006-01-02 15:04"), match.role, match.snippet})
+		}
+		catchErr(pterm.DefaultTable.WithHasHeader().WithData(data).Render())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(sessionsCmd)
+	sessionsCmd.AddCommand(sessionsListCmd, sessionsSearchCmd)
+}
+
+// loadSessions reads the saved conversations, newest first, skipping any that can't be parsed
+func loadSessions() ([]savedSession, error) {
+	paths, err := filepath.Glob(sessionPath("*"))
+	if err != nil {
+		return nil, err
+	}
+	var sessions []savedSession
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		session := savedSession{Name: strings.TrimSuffix(filepath.Base(path), ".json")}
+		if err := json.Unmarshal(data, &session.chatSession); err != nil {
+			catchErr(fmt.Errorf("skipping %s: %w", path, err))
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].Time.After(sessions[j].Time)
+	})
+	return sessions, nil
+}
+
+// searchSessions returns the messages containing term, ignoring case
+func searchSessions(sessions []savedSession, term string) []sessionMatch {
+	var matches []sessionMatch
+	for _, session := range sessions {
+		for _, message := range session.Messages {
+			if snippet, ok := matchSnippet(message.Content, term); ok {
+				matches = append(matches, sessionMatch{session, message.Role, snippet})
+			}
+		}
+	}
+	return matches
+}
+
+// matchSnippet returns the first match of term in content with some context
+// either side, on one line and with the match highlighted
+func matchSnippet(content, term string) (string, bool) {
+	text := []rune(strings.Join(strings.Fields(content), " "))
+	lower := []rune(strings.ToLower(string(text)))
+	query := []rune(strings.ToLower(strings.Join(strings.Fields(term), " ")))
+	if len(query) == 0 {
+		return "", false
+	}
+	if len(lower) != len(text) {
+		lower, query = text, []rune(strings.Join(strings.Fields(term), " ")) // lowercasing changed the length, match case instead
+	}
+	index := runeIndex(lower, query)
+	if index < 0 {
+		return "", false
+	}
+	start, end := max(index-snippetContext, 0), min(index+len(query)+snippetContext, len(text))
+	snippet := string(text[start:index]) + pterm.FgYellow.Sprint(string(text[index:index+len(query)])) + string(text[index+len(query):end])
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(text) {
+		snippet += "…"
+	}
+	return snippet, true
+}
+
+// runeIndex is strings.Index for runes, so the index can slice the original text
+func runeIndex(s, sub []rune) int {
+	for i := 0; i+len(sub) <= len(s); i++ {
+		if string(s[i:i+len(sub)]) == string(sub) {
+			return i
+		}
+	}
+	return -1
+}
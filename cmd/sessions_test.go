@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/x/ansi"
+	"github.com/pterm/pterm"
+)
+
+func TestMatchSnippet(t *testing.T) {
+	long := strings.Repeat("a", 50)
+	tests := []struct {
+		name, content, term string
+		want, match         string // the snippet without highlighting, and the highlighted match
+		ok                  bool
+	}{
+		{"match", "hello world", "world", "hello world", "world", true},
+		{"ignores case", "Hello World", "hello", "Hello World", "Hello", true},
+		{"no match", "hello world", "moon", "", "", false},
+		{"empty term", "hello world", "  ", "", "", false},
+		{"joins lines", "first line\n\n  second line", "line second", "first line second line", "line second", true},
+		{"first match", "one two one", "one", "one two one", "one", true},
+		{"context is trimmed", long + " needle " + long, "needle", "…" + strings.Repeat("a", 39) + " needle " + strings.Repeat("a", 39) + "…", "needle", true},
+		{"multibyte", "naïve café", "CAFÉ", "naïve café", "café", true},
+		{"lowercasing changes the length", "İstanbul trip", "trip", "İstanbul trip", "trip", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := matchSnippet(tt.content, tt.term)
+			if ok != tt.ok {
+				t.Fatalf("matchSnippet() ok = %v, want %v", ok, tt.ok)
+			}
+			if plain := ansi.Strip(got); plain != tt.want {
+				t.Errorf("matchSnippet() = %q, want %q", plain, tt.want)
+			}
+			if ok && !strings.Contains(got, pterm.FgYellow.Sprint(tt.match)) {
+				t.Errorf("matchSnippet() = %q, want %q highlighted", got, tt.match)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/openai/openai-go/v3"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// promptTemplate is a reusable prompt stored in templates_dir as <name>.yaml,
+// the system prompt and prompt can use {{.var}} placeholders
+type promptTemplate struct {
+	Name        string            `yaml:"-"`
+	Description string            `yaml:"description"`
+	System      string            `yaml:"system"`
+	Model       string            `yaml:"model,omitempty"`
+	Temperature *float64          `yaml:"temperature,omitempty"`
+	MaxTokens   int64             `yaml:"maxTokens,omitempty"`
+	Prompt      string            `yaml:"prompt"`
+	Vars        map[string]string `yaml:"vars,omitempty"` // defaults for placeholders
+}
+
+// Written by "ponder templates new"
+const templateSkeleton = `description: Summarize text
+system: You are a concise assistant. Reply in {{.lang}}.
+# model: gpt-4o-mini
+# temperature: 0.3
+prompt: |
+  Summarize the following in a few bullet points:
+
+  {{.input}}
+vars:
+  lang: English
+`
+
+var templatesCmd = &cobra.Command{
+	Use:   "templates",
+	Short: "Manage prompt templates",
+	Long: `Manage the prompt templates in templates_dir (default ~/.ponder/templates).
+	Each template is a YAML file with a system prompt, model, parameters and a prompt with {{.var}} placeholders.
+	Run a template with "ponder run <name>".
+	`,
+}
+
+var templatesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List prompt templates",
+	Run: func(cmd *cobra.Command, args []string) {
+		templates, err := loadTemplates()
+		catchErr(err, "fatal")
+		if len(templates) == 0 {
+			fmt.Println("No templates yet, create one with: ponder templates new <name>")
+			return
+		}
+		data := pterm.TableData{{"Name", "Description", "Model"}}
+		for _, t := range templates {
+			data = append(data, []string{t.Name, t.Description, t.Model})
+		}
+		catchErr(pterm.DefaultTable.WithHasHeader().WithData(data).Render())
+	},
+}
+
+var templatesShowCmd = &cobra.Command{
+	Use:   "show <name>",
+	Short: "Show a prompt template",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path, err := templatePath(args[0])
+		catchErr(err, "fatal")
+		data, err := os.ReadFile(path)
+		catchErr(err, "fatal")
+		fmt.Print(string(data))
+	},
+}
+
+var templatesNewCmd = &cobra.Command{
+	Use:   "new <name>",
+	Short: "Create a prompt template and open it in $EDITOR",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path, err := templatePath(args[0])
+		catchErr(err, "fatal")
+		if _, err := os.Stat(path); err == nil {
+			catchErr(fmt.Errorf("template %q already exists: %s", args[0], path), "fatal")
+		}
+		catchErr(os.MkdirAll(filepath.Dir(path), os.ModePerm), "fatal")
+		catchErr(os.WriteFile(path, []byte(templateSkeleton), 0o644), "fatal")
+		fmt.Println("📝 Created Template:", path)
+
+		if editor := os.Getenv("EDITOR"); editor != "" {
+			edit := exec.Command(editor, path)
+			edit.Stdin, edit.Stdout, edit.Stderr = os.Stdin, os.Stdout, os.Stderr
+			catchErr(edit.Run())
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(templatesCmd)
+	templatesCmd.AddCommand(templatesListCmd, templatesShowCmd, templatesNewCmd)
+}
+
+// templatePath returns the file of the named template, rejecting names that
+// would reach outside templates_dir
+func templatePath(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || !filepath.IsLocal(name+".yaml") || strings.Contains(name, "..") {
+		return "", fmt.Errorf("invalid template name %q, use a name without slashes or \"..\"", name)
+	}
+	return filepath.Join(expandHome(viper.GetString("templates_dir")), name+".yaml"), nil
+}
+
+// loadTemplate reads the named template from templates_dir
+func loadTemplate(name string) (*promptTemplate, error) {
+	path, err := templatePath(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("template %q not found, see \"ponder templates list\"", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	t := &promptTemplate{Name: name}
+	if err := yaml.Unmarshal(data, t); err != nil {
+		return nil, fmt.Errorf("invalid template %q: %w", name, err)
+	}
+	return t, nil
+}
+
+// loadTemplates reads all templates in templates_dir, sorted by name
+func loadTemplates() ([]*promptTemplate, error) {
+	paths, err := filepath.Glob(filepath.Join(expandHome(viper.GetString("templates_dir")), "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	var templates []*promptTemplate
+	for _, path := range paths {
+		t, err := loadTemplate(strings.TrimSuffix(filepath.Base(path), ".yaml"))
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, nil
+}
+
+// render fills in the placeholders of the system prompt and prompt, vars
+// override the template's defaults and missing variables are an error
+func (t *promptTemplate) render(vars map[string]string) (system, prompt string, err error) {
+	values := map[string]string{"input": ""}
+	for k, v := range t.Vars {
+		values[k] = v
+	}
+	for k, v := range vars {
+		values[k] = v
+	}
+
+	fill := func(text string) (string, error) {
+		tmpl, err := template.New(t.Name).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return "", err
+		}
+		var b strings.Builder
+		if err := tmpl.Execute(&b, values); err != nil {
+			return "", err
+		}
+		return b.String(), nil
+	}
+	if system, err = fill(t.System); err != nil {
+		return "", "", fmt.Errorf("template %q: %w", t.Name, err)
+	}
+	if prompt, err = fill(t.Prompt); err != nil {
+		return "", "", fmt.Errorf("template %q: %w", t.Name, err)
+	}
+	return system, strings.TrimSpace(prompt), nil
+}
+
+// parseVars parses key=value pairs
+func parseVars(pairs []string) (map[string]string, error) {
+	vars := map[string]string{}
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid variable %q, use key=value", pair)
+		}
+		vars[key] = value
+	}
+	return vars, nil
+}
+
+// templateCommand handles "/template <name> [key=value ...]" in the chat TUI,
+// applying the template's system prompt and model to the chat and placing
+// its prompt in the input to edit and send
+func templateCommand(m *chatHistoryModel, args string) tea.Cmd {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		templates, err := loadTemplates()
+		if err != nil {
+			m.addSystemMessage("❌ " + err.Error())
+			return nil
+		}
+		var names []string
+		for _, t := range templates {
+			names = append(names, t.Name)
+		}
+		m.addSystemMessage("Templates: " + strings.Join(names, ", ") + "\nUsage: /template <name> [key=value ...]")
+		return nil
+	}
+
+	prompt, err := applyChatTemplate(fields[0], fields[1:])
+	if err != nil {
+		m.addSystemMessage("❌ " + err.Error())
+		return nil
+	}
+	m.textarea.SetValue(prompt)
+	m.addSystemMessage(fmt.Sprintf("📝 Using template %q, edit the prompt and press %s to send", fields[0], m.keys.Send.Help().Key))
+	return nil
+}
+
+// applyChatTemplate uses a template's system prompt, model and parameters
+// for the rest of the chat, returning its rendered prompt
+func applyChatTemplate(name string, pairs []string) (string, error) {
+	t, err := loadTemplate(name)
+	if err != nil {
+		return "", err
+	}
+	vars, err := parseVars(pairs)
+	if err != nil {
+		return "", err
+	}
+	system, prompt, err := t.render(vars)
+	if err != nil {
+		return "", err
+	}
+
+	if system != "" {
+		ponderContext.setSystemMessage(openai.DeveloperMessage(system))
+	}
+	if t.Model != "" {
+		chatSettings.model = t.Model
+	}
+	if t.Temperature != nil {
+		chatSettings.temperature = t.Temperature
+	}
+	if t.MaxTokens > 0 {
+		chatSettings.maxTokens = t.MaxTokens
+	}
+	return prompt, nil
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestTemplatePath(t *testing.T) {
+	dir := t.TempDir()
+	viper.Set("templates_dir", dir)
+	defer viper.Set("templates_dir", nil)
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"review", filepath.Join(dir, "review.yaml"), false},
+		{"code-review.v2", filepath.Join(dir, "code-review.v2.yaml"), false},
+		{"", "", true},
+		{"../secrets", "", true},
+		{"..", "", true},
+		{"sub/review", "", true},
+		{`sub\review`, "", true},
+		{"/etc/passwd", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := templatePath(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("templatePath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("templatePath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
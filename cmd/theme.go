@@ -0,0 +1,207 @@
+package cmd
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/glamour/ansi"
+	"github.com/charmbracelet/glamour/styles"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/viper"
+)
+
+// tuiColors are the ANSI (0-255) or hex colors of the chat TUI
+type tuiColors struct {
+	Title     string `mapstructure:"title"`
+	User      string `mapstructure:"user"`
+	Assistant string `mapstructure:"assistant"`
+	System    string `mapstructure:"system"` // system messages
+	Help      string `mapstructure:"help"`   // the help line, code block numbers and alternatives
+	Match     string `mapstructure:"match"`  // the current search match
+}
+
+// tuiTheme styles the chat TUI and the code in answers
+type tuiTheme struct {
+	Colors        tuiColors `mapstructure:"colors"`
+	ChromaStyle   string    `mapstructure:"chromaStyle"`   // code highlighting, see https://xyproto.github.io/splash/docs/
+	MarkdownStyle string    `mapstructure:"markdownStyle"` // a glamour style name or JSON style file
+}
+
+// tuiKeys are the keys of the chat TUI, in Bubble Tea's names such as "ctrl+d" or "alt+enter"
+type tuiKeys struct {
+	Send           []string `mapstructure:"send"`
+	Newline        []string `mapstructure:"newline"`
+	Quit           []string `mapstructure:"quit"`
+	Cancel         []string `mapstructure:"cancel"` // a request, an edit or the search
+	Complete       []string `mapstructure:"complete"`
+	Editor         []string `mapstructure:"editor"`
+	Search         []string `mapstructure:"search"`
+	Copy           []string `mapstructure:"copy"`
+	Regenerate     []string `mapstructure:"regenerate"`
+	EditPrevious   []string `mapstructure:"editPrevious"`
+	EditNext       []string `mapstructure:"editNext"`
+	SwitchPrevious []string `mapstructure:"switchPrevious"`
+	SwitchNext     []string `mapstructure:"switchNext"`
+	GrowInput      []string `mapstructure:"growInput"`
+	ShrinkInput    []string `mapstructure:"shrinkInput"`
+}
+
+// tuiConfig is the tui config section, colors and styles left empty come from the theme
+type tuiConfig struct {
+	Theme          string `mapstructure:"theme"` // auto, dark, light or high-contrast
+	tuiTheme       `mapstructure:",squash"`
+	UserLabel      string  `mapstructure:"userLabel"`
+	AssistantLabel string  `mapstructure:"assistantLabel"`
+	Keys           tuiKeys `mapstructure:"keys"`
+	noColor        bool
+}
+
+// Built-in themes for tui.theme
+var tuiThemes = map[string]tuiTheme{
+	"dark": {
+		Colors:        tuiColors{Title: "212", User: "86", Assistant: "212", System: "240", Help: "240", Match: "220"},
+		ChromaStyle:   "monokai",
+		MarkdownStyle: styles.DarkStyle,
+	},
+	"light": {
+		Colors:        tuiColors{Title: "125", User: "30", Assistant: "125", System: "244", Help: "244", Match: "220"},
+		ChromaStyle:   "github",
+		MarkdownStyle: styles.LightStyle,
+	},
+	"high-contrast": {
+		Colors:        tuiColors{Title: "15", User: "14", Assistant: "11", System: "15", Help: "15", Match: "11"},
+		ChromaStyle:   "hr_high_contrast",
+		MarkdownStyle: styles.DarkStyle,
+	},
+}
+
+// tui is the chat TUI's configuration, loaded once as "auto" asks the
+// terminal for its background, which can't be done while the TUI runs
+var tui = sync.OnceValue(loadTUIConfig)
+
+// loadTUIConfig reads the tui config section over its theme. With NO_COLOR
+// set, colors are turned off and Markdown is rendered as plain text
+func loadTUIConfig() tuiConfig {
+	// Unmarshal merges the defaults of keys missing from the section, unlike UnmarshalKey
+	var settings struct {
+		TUI tuiConfig `mapstructure:"tui"`
+	}
+	catchErr(viper.Unmarshal(&settings))
+	config := settings.TUI
+
+	name := strings.ToLower(config.Theme)
+	if name == "" || name == "auto" {
+		name = "light"
+		if lipgloss.HasDarkBackground() {
+			name = "dark"
+		}
+	}
+	theme, ok := tuiThemes[name]
+	if !ok {
+		catchErr(fmt.Errorf("unknown tui.theme %q, using dark, available themes: auto, dark, light, high-contrast", config.Theme))
+		theme = tuiThemes["dark"]
+	}
+	config.Colors = tuiColors{
+		Title:     cmp.Or(config.Colors.Title, theme.Colors.Title),
+		User:      cmp.Or(config.Colors.User, theme.Colors.User),
+		Assistant: cmp.Or(config.Colors.Assistant, theme.Colors.Assistant),
+		System:    cmp.Or(config.Colors.System, theme.Colors.System),
+		Help:      cmp.Or(config.Colors.Help, theme.Colors.Help),
+		Match:     cmp.Or(config.Colors.Match, theme.Colors.Match),
+	}
+	config.ChromaStyle = cmp.Or(config.ChromaStyle, theme.ChromaStyle)
+	config.MarkdownStyle = cmp.Or(config.MarkdownStyle, theme.MarkdownStyle)
+
+	if slices.ContainsFunc(config.Keys.Newline, func(k string) bool { return slices.Contains(config.Keys.Send, k) }) {
+		config.Keys.Newline = []string{"alt+enter", "ctrl+j"} // sending with Enter, terminals report Shift+Enter as one of these if at all
+	}
+
+	if noColor() {
+		// Bold, italics and reverse video are kept, only colors are dropped
+		config.noColor = true
+		config.Colors = tuiColors{}
+		config.MarkdownStyle = styles.NoTTYStyle
+	}
+	return config
+}
+
+// noColor reports whether colors are turned off with NO_COLOR, see https://no-color.org
+func noColor() bool {
+	return os.Getenv("NO_COLOR") != ""
+}
+
+// markdownStyle loads the Markdown style, a glamour style name or JSON style
+// file, with code highlighted in the chroma style
+func (c tuiConfig) markdownStyle() (ansi.StyleConfig, error) {
+	var style ansi.StyleConfig
+	if standard, ok := styles.DefaultStyles[c.MarkdownStyle]; ok {
+		style = *standard
+	} else {
+		data, err := os.ReadFile(expandHome(c.MarkdownStyle))
+		if err != nil {
+			return style, fmt.Errorf("markdown style %q: %w", c.MarkdownStyle, err)
+		}
+		if err := json.Unmarshal(data, &style); err != nil {
+			return style, fmt.Errorf("markdown style %q: %w", c.MarkdownStyle, err)
+		}
+	}
+	if !c.noColor && c.ChromaStyle != "" {
+		style.CodeBlock.Theme = c.ChromaStyle
+		style.CodeBlock.Chroma = nil // glamour's own colors would be used instead of the theme
+	}
+	return style, nil
+}
+
+// chatKeyMap is the chat TUI's key bindings, from tui.keys
+type chatKeyMap struct {
+	Send, Newline, Quit, Cancel, Complete, Editor, Search, Copy, Regenerate    key.Binding
+	EditPrevious, EditNext, SwitchPrevious, SwitchNext, GrowInput, ShrinkInput key.Binding
+}
+
+func newChatKeyMap(keys tuiKeys) chatKeyMap {
+	binding := func(keys []string) key.Binding {
+		return key.NewBinding(key.WithKeys(keys...), key.WithHelp(keyName(keys), ""))
+	}
+	return chatKeyMap{
+		Send:           binding(keys.Send),
+		Newline:        binding(keys.Newline),
+		Quit:           binding(keys.Quit),
+		Cancel:         binding(keys.Cancel),
+		Complete:       binding(keys.Complete),
+		Editor:         binding(keys.Editor),
+		Search:         binding(keys.Search),
+		Copy:           binding(keys.Copy),
+		Regenerate:     binding(keys.Regenerate),
+		EditPrevious:   binding(keys.EditPrevious),
+		EditNext:       binding(keys.EditNext),
+		SwitchPrevious: binding(keys.SwitchPrevious),
+		SwitchNext:     binding(keys.SwitchNext),
+		GrowInput:      binding(keys.GrowInput),
+		ShrinkInput:    binding(keys.ShrinkInput),
+	}
+}
+
+// keyName shows the first of keys for the help line, "ctrl+d" as "Ctrl+D"
+func keyName(keys []string) string {
+	if len(keys) == 0 {
+		return ""
+	}
+	arrows := map[string]string{"up": "↑", "down": "↓", "left": "←", "right": "→"}
+	parts := strings.Split(keys[0], "+")
+	for i, part := range parts {
+		if arrow, ok := arrows[part]; ok {
+			parts[i] = arrow
+		} else if len(part) == 1 {
+			parts[i] = strings.ToUpper(part)
+		} else {
+			parts[i] = strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return strings.Join(parts, "+")
+}
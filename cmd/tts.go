@@ -60,47 +60,62 @@ func initialTTSHistoryModel() chatHistoryModel {
 		Placeholder:     "Enter text to convert to speech...",
 		UserLabel:       "Text: ",
 		AssistantLabel:  "Playing Audio",
-		UserColor:       userColor,
-		AssistantColor:  assistantColor,
 		ResponseHandler: ttsResponse,
 	})
 }
 
-func ttsResponse(text string) (string, []byte) {
+func ttsResponse(ctx context.Context, text string) (string, []byte, error) {
 	spinner, _ = ponderSpinner.Start()
-	audio := tts(text)
+	audio, err := tts(ctx, text)
 	spinner.Stop()
 	if audio != nil {
 		go playAudio(audio)
 	}
-	return "", nil
+	return "", nil, err
 }
 
-func tts(text string) []byte {
+func tts(ctx context.Context, text string) ([]byte, error) {
+	if _, err := checkBudget("tts", ""); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := openaiContext(ctx)
+	defer cancel()
+	audioData, err := ttsAudio(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+	recordTTSUsage("tts", usageSession, "", text)
+
+	if audioFile != "" {
+		file, err := os.Create(audioFile)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		_, err = io.Copy(file, bytes.NewReader(audioData))
+		return nil, err
+	}
+	return audioData, nil
+}
+
+// ttsAudio converts text to speech in the --voice flag's voice, or
+// openAI_tts_voice if empty, returning the audio
+func ttsAudio(ctx context.Context, text string) ([]byte, error) {
 	voiceToUse := voice
 	if voiceToUse == "" {
 		voiceToUse = viper.GetString("openAI_tts_voice")
 	}
 
-	res, err := ai.Audio.Speech.New(context.Background(), openai.AudioSpeechNewParams{
+	res, err := ai.Audio.Speech.New(ctx, openai.AudioSpeechNewParams{
 		Input: text,
 		Model: openai.AudioModel(viper.GetString("openAI_tts_model")),
 		Voice: openai.AudioSpeechNewParamsVoice(voiceToUse),
 		Speed: openai.Float(viper.GetFloat64("openAI_tts_speed")),
 	})
-	catchErr(err, "fatal")
-	defer res.Body.Close()
-
-	audioData, err := io.ReadAll(res.Body)
-	catchErr(err, "fatal")
-
-	if audioFile != "" {
-		file, err := os.Create(audioFile)
-		catchErr(err)
-		defer file.Close()
-		_, err = io.Copy(file, bytes.NewReader(audioData))
-		catchErr(err)
-		return nil
+	if err != nil {
+		return nil, err
 	}
-	return audioData
+	defer res.Body.Close()
+	return io.ReadAll(res.Body)
 }
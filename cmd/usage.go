@@ -0,0 +1,432 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// usageRecord is a single entry in the usage ledger
+type usageRecord struct {
+	Time         time.Time `json:"time"`
+	Command      string    `json:"command"`
+	Model        string    `json:"model"`
+	Session      string    `json:"session,omitempty"`
+	Guild        string    `json:"guild,omitempty"`
+	User         string    `json:"user,omitempty"`
+	InputTokens  int64     `json:"inputTokens,omitempty"`
+	OutputTokens int64     `json:"outputTokens,omitempty"`
+	Images       int64     `json:"images,omitempty"`
+	ImageSize    string    `json:"imageSize,omitempty"`
+	ImageQuality string    `json:"imageQuality,omitempty"`
+	TTSChars     int64     `json:"ttsChars,omitempty"`
+	Cost         float64   `json:"cost"`
+}
+
+// usagePrice is the price of a model in USD, configured in usage_prices
+type usagePrice struct {
+	Model      string  `mapstructure:"model"`
+	Input      float64 `mapstructure:"input"`      // per 1M input tokens
+	Output     float64 `mapstructure:"output"`     // per 1M output tokens
+	Image      float64 `mapstructure:"image"`      // per image
+	Characters float64 `mapstructure:"characters"` // per 1M TTS characters
+
+	// Per image by size, or quality and size such as "hd 1024x1792", replacing image
+	Images map[string]float64 `mapstructure:"images"`
+}
+
+// Prices parsed from usage_prices, parsed again when the config file changes
+var usagePrices struct {
+	mu     sync.Mutex
+	loaded bool
+	prices []usagePrice
+}
+
+// usageLedger appends usage records to a JSON Lines file, keeping running
+// totals of today's and this month's spending for budgets and quotas
+type usageLedger struct {
+	mu       sync.Mutex
+	day      string                 // day the spending totals are for, empty until loaded
+	daily    map[string]float64     // cost today by spend key
+	monthly  map[string]float64     // cost this month by spend key
+	sessions map[string]usageRecord // running totals per session
+}
+
+var ledger = &usageLedger{sessions: map[string]usageRecord{}}
+
+// Identifies usage from this run of the CLI in the ledger
+var usageSession = time.Now().Format("20060102-150405")
+
+var usageSince, usageBy string
+
+var usageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "Report token usage and cost",
+	Long: `Report token, image and TTS usage and the estimated cost recorded in the usage ledger.
+	Prices are configured in usage_prices, in USD per 1M tokens, per image and per 1M TTS characters.
+	`,
+	Run: func(cmd *cobra.Command, args []string) {
+		since, err := parseSince(usageSince)
+		catchErr(err, "fatal")
+		records, err := readUsage(since)
+		catchErr(err, "fatal")
+		catchErr(renderUsage(records, usageBy), "fatal")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(usageCmd)
+	usageCmd.Flags().StringVar(&usageSince, "since", "30d", "Only include usage since a duration ago (e.g. 24h, 7d) or date (YYYY-MM-DD)")
+	usageCmd.Flags().StringVar(&usageBy, "by", "command", "Group usage by: command, model, session, guild or day")
+}
+
+func usageLedgerFile() string {
+	return expandHome(viper.GetString("usage_ledgerFile"))
+}
+
+// recordUsage prices a usage record and appends it to the ledger
+func recordUsage(record usageRecord) {
+	record.Time = time.Now()
+	record.Cost = usageCost(record)
+
+	ledger.mu.Lock()
+	defer ledger.mu.Unlock()
+
+	if ledger.day != "" {
+		if err := ledger.refresh(record.Time); err != nil {
+			catchErr(err)
+		} else {
+			ledger.add(record)
+		}
+	}
+	if record.Session != "" {
+		total := ledger.sessions[record.Session]
+		total.InputTokens += record.InputTokens
+		total.OutputTokens += record.OutputTokens
+		total.Images += record.Images
+		total.TTSChars += record.TTSChars
+		total.Cost += record.Cost
+		ledger.sessions[record.Session] = total
+	}
+
+	path := usageLedgerFile()
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		catchErr(err)
+		return
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		catchErr(err)
+		return
+	}
+	defer file.Close()
+	err = json.NewEncoder(file).Encode(record)
+	catchErr(err)
+}
+
+// recordChatUsage records the token usage of a chat completion
+func recordChatUsage(command, session, guild, user string, res *openai.ChatCompletion) {
+	recordUsage(usageRecord{
+		Command:      command,
+		Model:        res.Model,
+		Session:      session,
+		Guild:        guild,
+		User:         user,
+		InputTokens:  res.Usage.PromptTokens,
+		OutputTokens: res.Usage.CompletionTokens,
+	})
+}
+
+// recordImageUsage records generated images with their size and quality,
+// and the tokens used by gpt-image models
+func recordImageUsage(command, model, size, quality, session, guild, user string, res *openai.ImagesResponse) {
+	recordUsage(usageRecord{
+		Command:      command,
+		Model:        model,
+		Session:      session,
+		Guild:        guild,
+		User:         user,
+		InputTokens:  res.Usage.InputTokens,
+		OutputTokens: res.Usage.OutputTokens,
+		Images:       int64(len(res.Data)),
+		ImageSize:    size,
+		ImageQuality: quality,
+	})
+}
+
+// recordTTSUsage records the characters converted to speech
+func recordTTSUsage(command, session, guild, text string) {
+	recordUsage(usageRecord{
+		Command:  command,
+		Model:    viper.GetString("openAI_tts_model"),
+		Session:  session,
+		Guild:    guild,
+		TTSChars: int64(len([]rune(text))),
+	})
+}
+
+// sessionUsage returns the running totals for a session
+func sessionUsage(session string) usageRecord {
+	ledger.mu.Lock()
+	defer ledger.mu.Unlock()
+	return ledger.sessions[session]
+}
+
+// readUsage reads the records in the ledger file from the given time onwards
+func readUsage(since time.Time) ([]usageRecord, error) {
+	var records []usageRecord
+	err := scanLedger(func(record usageRecord) {
+		if !record.Time.Before(since) {
+			records = append(records, record)
+		}
+	})
+	return records, err
+}
+
+// scanLedger calls fn with each record in the ledger file
+func scanLedger(fn func(usageRecord)) error {
+	file, err := os.Open(usageLedgerFile())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record usageRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue // Skip partially written lines
+		}
+		fn(record)
+	}
+	return scanner.Err()
+}
+
+// spendKeys are the totals a record's cost counts towards, all spending,
+// its command's, and its guild's and user's when it has them
+func spendKeys(record usageRecord) []string {
+	keys := []string{"", "command:" + record.Command}
+	if record.Guild != "" {
+		keys = append(keys, "guild:"+record.Guild)
+	}
+	if record.User != "" {
+		keys = append(keys, "user:"+record.User)
+	}
+	return keys
+}
+
+// spent returns the cost today and this month counted towards a spend key,
+// loading the totals from the ledger file the first time
+func (l *usageLedger) spent(key string) (daily, monthly float64, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.refresh(time.Now()); err != nil {
+		return 0, 0, err
+	}
+	return l.daily[key], l.monthly[key], nil
+}
+
+// refresh rolls the totals over to the day of now, loading this month's
+// totals from the ledger file when the month changes, the caller must hold the lock
+func (l *usageLedger) refresh(now time.Time) error {
+	now = now.Local()
+	today := now.Format(time.DateOnly)
+	switch {
+	case l.day == today:
+		return nil
+	case l.day != "" && l.day[:7] == today[:7]: // same month
+		l.daily = map[string]float64{}
+	default:
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+		l.day, l.daily, l.monthly = today, map[string]float64{}, map[string]float64{}
+		err := scanLedger(func(record usageRecord) {
+			if !record.Time.Before(start) {
+				l.add(record)
+			}
+		})
+		if err != nil {
+			l.day = "" // load again next time
+			return err
+		}
+	}
+	l.day = today
+	return nil
+}
+
+// add counts a record from this month towards the totals, the caller must hold the lock
+func (l *usageLedger) add(record usageRecord) {
+	today := record.Time.Local().Format(time.DateOnly) == l.day
+	for _, key := range spendKeys(record) {
+		l.monthly[key] += record.Cost
+		if today {
+			l.daily[key] += record.Cost
+		}
+	}
+}
+
+// usageCost estimates the cost of a record in USD from usage_prices
+func usageCost(record usageRecord) float64 {
+	price, ok := modelPrice(record.Model)
+	if !ok {
+		return 0
+	}
+	return float64(record.InputTokens)*price.Input/1e6 +
+		float64(record.OutputTokens)*price.Output/1e6 +
+		float64(record.Images)*price.imagePrice(record.ImageSize, record.ImageQuality) +
+		float64(record.TTSChars)*price.Characters/1e6
+}
+
+// imagePrice is the price of an image of a size and quality, falling back
+// to the size alone, then the image price
+func (p usagePrice) imagePrice(size, quality string) float64 {
+	if price, ok := p.Images[strings.ToLower(quality+" "+size)]; ok && quality != "" {
+		return price
+	}
+	if price, ok := p.Images[strings.ToLower(size)]; ok {
+		return price
+	}
+	return p.Image
+}
+
+// loadPrices parses usage_prices the first time prices are needed
+func loadPrices() []usagePrice {
+	usagePrices.mu.Lock()
+	defer usagePrices.mu.Unlock()
+	if !usagePrices.loaded {
+		usagePrices.prices = nil
+		catchErr(viper.UnmarshalKey("usage_prices", &usagePrices.prices))
+		usagePrices.loaded = true
+	}
+	return usagePrices.prices
+}
+
+// reloadPrices parses usage_prices again the next time prices are needed
+func reloadPrices() {
+	usagePrices.mu.Lock()
+	defer usagePrices.mu.Unlock()
+	usagePrices.loaded = false
+}
+
+// modelPrice finds the price for a model, falling back to the longest
+// configured prefix so dated model versions use their family's price
+func modelPrice(model string) (usagePrice, bool) {
+	prices := loadPrices()
+	var best usagePrice
+	var found bool
+	for _, price := range prices {
+		if price.Model == model {
+			return price, true
+		}
+		if strings.HasPrefix(model, price.Model) && len(price.Model) > len(best.Model) {
+			best, found = price, true
+		}
+	}
+	return best, found
+}
+
+// parseSince parses a duration ago such as 24h or 7d, or a YYYY-MM-DD date
+func parseSince(since string) (time.Time, error) {
+	if since == "" {
+		return time.Time{}, nil
+	}
+	if date, err := time.ParseInLocation(time.DateOnly, since, time.Local); err == nil {
+		return date, nil
+	}
+	if days, ok := strings.CutSuffix(since, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid --since %q", since)
+		}
+		return time.Now().AddDate(0, 0, -n), nil
+	}
+	duration, err := time.ParseDuration(since)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --since %q, use a duration like 24h or 7d, or a date like 2006-01-02", since)
+	}
+	return time.Now().Add(-duration), nil
+}
+
+// renderUsage prints a table of usage grouped by command, model, session, guild or day
+func renderUsage(records []usageRecord, by string) error {
+	key := map[string]func(usageRecord) string{
+		"command": func(r usageRecord) string { return r.Command },
+		"model":   func(r usageRecord) string { return r.Model },
+		"session": func(r usageRecord) string { return r.Session },
+		"guild":   func(r usageRecord) string { return r.Guild },
+		"day":     func(r usageRecord) string { return r.Time.Local().Format(time.DateOnly) },
+	}[by]
+	if key == nil {
+		return fmt.Errorf("invalid --by %q, use command, model, session, guild or day", by)
+	}
+	if len(records) == 0 {
+		fmt.Println("No usage recorded yet")
+		return nil
+	}
+
+	groups := map[string]*usageRecord{}
+	counts := map[string]int{}
+	var total usageRecord
+	for _, record := range records {
+		name := key(record)
+		if name == "" {
+			name = "-"
+		}
+		group := groups[name]
+		if group == nil {
+			group = &usageRecord{}
+			groups[name] = group
+		}
+		for _, sum := range []*usageRecord{group, &total} {
+			sum.InputTokens += record.InputTokens
+			sum.OutputTokens += record.OutputTokens
+			sum.Images += record.Images
+			sum.TTSChars += record.TTSChars
+			sum.Cost += record.Cost
+		}
+		counts[name]++
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if by == "day" {
+			return names[i] < names[j]
+		}
+		return groups[names[i]].Cost > groups[names[j]].Cost
+	})
+
+	data := pterm.TableData{{strings.ToUpper(by[:1]) + by[1:], "Requests", "Input Tokens", "Output Tokens", "Images", "TTS Chars", "Cost"}}
+	row := func(name string, requests int, r usageRecord) []string {
+		return []string{
+			name,
+			strconv.Itoa(requests),
+			strconv.FormatInt(r.InputTokens, 10),
+			strconv.FormatInt(r.OutputTokens, 10),
+			strconv.FormatInt(r.Images, 10),
+			strconv.FormatInt(r.TTSChars, 10),
+			fmt.Sprintf("$%.4f", r.Cost),
+		}
+	}
+	for _, name := range names {
+		data = append(data, row(name, counts[name], *groups[name]))
+	}
+	data = append(data, row("Total", len(records), total))
+	return pterm.DefaultTable.WithHasHeader().WithData(data).Render()
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestUsageCost(t *testing.T) {
+	viper.Set("usage_prices", []map[string]any{
+		{"model": "gpt-4o", "input": 2.5, "output": 10.0},
+		{"model": "gpt-4o-mini", "input": 0.15, "output": 0.6},
+		{"model": "dall-e-3", "image": 0.04, "images": map[string]float64{"1024x1792": 0.08, "hd 1024x1024": 0.08, "hd 1024x1792": 0.12}},
+		{"model": "tts-1", "characters": 15.0},
+	})
+	reloadPrices()
+	defer func() {
+		viper.Set("usage_prices", nil)
+		reloadPrices()
+	}()
+	tests := []struct {
+		name   string
+		record usageRecord
+		want   float64
+	}{
+		{"tokens", usageRecord{Model: "gpt-4o", InputTokens: 1e6, OutputTokens: 1e6}, 12.5},
+		{"longest prefix", usageRecord{Model: "gpt-4o-mini-2024-07-18", InputTokens: 1e6}, 0.15},
+		{"unknown model", usageRecord{Model: "o9", InputTokens: 1e6}, 0},
+		{"square image", usageRecord{Model: "dall-e-3", Images: 2, ImageSize: "1024x1024"}, 0.08},
+		{"standard quality", usageRecord{Model: "dall-e-3", Images: 1, ImageSize: "1024x1024", ImageQuality: "standard"}, 0.04},
+		{"tall image", usageRecord{Model: "dall-e-3", Images: 1, ImageSize: "1024x1792"}, 0.08},
+		{"hd image", usageRecord{Model: "dall-e-3", Images: 1, ImageSize: "1024x1024", ImageQuality: "hd"}, 0.08},
+		{"hd tall image", usageRecord{Model: "dall-e-3", Images: 1, ImageSize: "1024x1792", ImageQuality: "hd"}, 0.12},
+		{"tts", usageRecord{Model: "tts-1", TTSChars: 1000}, 0.015},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := usageCost(tt.record); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("usageCost() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLedgerSpent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.jsonl")
+	viper.Set("usage_ledgerFile", path)
+	defer viper.Set("usage_ledgerFile", nil)
+
+	now := time.Now()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoder := json.NewEncoder(file)
+	for _, record := range []usageRecord{
+		{Time: time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).Add(-time.Hour), Command: "chat", Cost: 100},
+		{Time: now, Command: "chat", Cost: 1},
+		{Time: now, Command: "discord-chat", Guild: "g1", User: "u1", Cost: 2},
+	} {
+		catchErr(encoder.Encode(record))
+	}
+	if now.Day() > 1 {
+		catchErr(encoder.Encode(usageRecord{Time: now.AddDate(0, 0, -1), Command: "chat", Cost: 4}))
+	}
+	file.Close()
+
+	l := &usageLedger{sessions: map[string]usageRecord{}}
+	previous := ledger
+	ledger = l
+	defer func() { ledger = previous }()
+	yesterday := 0.0
+	if now.Day() > 1 {
+		yesterday = 4
+	}
+	tests := []struct {
+		name                   string
+		key                    string
+		wantDaily, wantMonthly float64
+	}{
+		{"all", "", 3, 3 + yesterday},
+		{"command", "command:chat", 1, 1 + yesterday},
+		{"guild", "guild:g1", 2, 2},
+		{"user", "user:u1", 2, 2},
+		{"nothing spent", "user:u2", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			daily, monthly, err := l.spent(tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if daily != tt.wantDaily || monthly != tt.wantMonthly {
+				t.Errorf("spent() = %v, %v, want %v, %v", daily, monthly, tt.wantDaily, tt.wantMonthly)
+			}
+		})
+	}
+
+	viper.Set("usage_prices", []map[string]any{{"model": "gpt-4o", "input": 2.0}})
+	reloadPrices()
+	defer func() {
+		viper.Set("usage_prices", nil)
+		reloadPrices()
+	}()
+	recordUsage(usageRecord{Command: "chat", Model: "gpt-4o", InputTokens: 1e6})
+	if daily, _, _ := l.spent("command:chat"); daily != 3 {
+		t.Errorf("daily spend after recording = %v, want 3", daily)
+	}
+
+	l.day = now.AddDate(0, 0, -1).Format(time.DateOnly) // the totals roll over to a new day
+	daily, monthly, _ := l.spent("command:chat")
+	if daily != 0 {
+		t.Errorf("daily spend on a new day = %v, want 0", daily)
+	}
+	if monthly != 3+yesterday {
+		t.Errorf("monthly spend on a new day = %v, want %v", monthly, 3+yesterday)
+	}
+}
@@ -5,8 +5,10 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"os/user"
 	"regexp"
 	"runtime"
 	"strings"
@@ -51,12 +53,12 @@ func syntaxHighlightString(message string) string {
 	var inCodeBlock bool
 	var currentLexer chroma.Lexer
 
-	style := styles.Get("monokai")
+	style := styles.Get(tui().ChromaStyle)
 	if style == nil {
 		style = styles.Fallback
 	}
 	formatter := formatters.Get("terminal256")
-	if formatter == nil {
+	if formatter == nil || noColor() {
 		formatter = formatters.Fallback
 	}
 
@@ -70,6 +72,9 @@ func syntaxHighlightString(message string) string {
 	reset := "\033[0m"   // Reset ANSI escape code
 
 	processLine := func(line string) string {
+		if noColor() {
+			return line
+		}
 		line = backtickRegex.ReplaceAllStringFunc(line, func(match string) string {
 			return cyan + strings.Trim(match, "`") + reset
 		})
@@ -221,3 +226,35 @@ func HashAPIKey(apiKey string) string {
 	hash := sha256.Sum256([]byte(apiKey))
 	return hex.EncodeToString(hash[:8])
 }
+
+// truncate shortens s to at most max runes, marking the cut with an ellipsis
+func truncate(s string, max int) string {
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+	return string(r[:max-1]) + "…"
+}
+
+// expandHome replaces a leading ~ in path with the current user's home directory
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~") {
+		return path
+	}
+	currentUser, err := user.Current()
+	if err != nil {
+		catchErr(err)
+		return path
+	}
+	return strings.Replace(path, "~", currentUser.HomeDir, 1)
+}
+
+// readStdin returns the input piped to the command, or "" if stdin is a terminal
+func readStdin() (string, error) {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice != 0 {
+		return "", err
+	}
+	input, err := io.ReadAll(os.Stdin)
+	return string(input), err
+}
@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/openai/openai-go/v3"
+	"github.com/spf13/viper"
+)
+
+// Image files passed with --image
+var imageFiles []string
+
+// Images (as data URLs) waiting to be sent with the next user message
+var pendingImages []string
+
+// Image types accepted by the vision models
+var visionContentTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// attachImage reads an image file and queues it to be sent with the next message
+func attachImage(path string) error {
+	data, err := os.ReadFile(expandHome(path))
+	if err != nil {
+		return err
+	}
+
+	contentType := http.DetectContentType(data)
+	if !visionContentTypes[contentType] {
+		return fmt.Errorf("%s is not a supported image (%s), use png, jpeg, gif or webp", filepath.Base(path), contentType)
+	}
+
+	pendingImages = append(pendingImages, "data:"+contentType+";base64,"+base64.StdEncoding.EncodeToString(data))
+	return nil
+}
+
+// userMessage builds a user message, sending it as content parts when it includes images
+func userMessage(text string, imageURLs []string) openai.ChatCompletionMessageParamUnion {
+	if len(imageURLs) == 0 {
+		return openai.UserMessage(text)
+	}
+
+	parts := []openai.ChatCompletionContentPartUnionParam{}
+	if text != "" {
+		parts = append(parts, openai.TextContentPart(text))
+	}
+	for _, url := range imageURLs {
+		parts = append(parts, openai.ImageContentPart(openai.ChatCompletionContentPartImageImageURLParam{
+			URL: url,
+		}))
+	}
+	return openai.UserMessage(parts)
+}
+
+// imagesKey is the context key of the images to send instead of the pending
+// ones, when a question is edited or regenerated
+type imagesKey struct{}
+
+// messageImages returns the URLs of the images in a user message
+func messageImages(message openai.ChatCompletionMessageParamUnion) []string {
+	if message.OfUser == nil {
+		return nil
+	}
+	var urls []string
+	for _, part := range message.OfUser.Content.OfArrayOfContentParts {
+		if part.OfImageURL != nil {
+			urls = append(urls, part.OfImageURL.ImageURL.URL)
+		}
+	}
+	return urls
+}
+
+// hasImageContent reports whether any of the messages include an image
+func hasImageContent(messages []openai.ChatCompletionMessageParamUnion) bool {
+	for _, message := range messages {
+		if message.OfUser == nil {
+			continue
+		}
+		for _, part := range message.OfUser.Content.OfArrayOfContentParts {
+			if part.OfImageURL != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// chatModel returns the chat session's model, or the vision model if the conversation includes images
+func chatModel(messages []openai.ChatCompletionMessageParamUnion) string {
+	if visionModel := viper.GetString("openAI_chat_visionModel"); visionModel != "" && hasImageContent(messages) {
+		return visionModel
+	}
+	if chatSettings.model != "" {
+		return chatSettings.model
+	}
+	return viper.GetString("openAI_chat_model")
+}
+
+// attachCommand handles "/attach <path>" in the chat TUI
+func attachCommand(m *chatHistoryModel, args string) tea.Cmd {
+	path := strings.TrimSpace(args)
+	if path == "" {
+		m.addSystemMessage("Usage: /attach <image path>")
+		return nil
+	}
+	if err := attachImage(path); err != nil {
+		m.addSystemMessage(fmt.Sprintf("Error: %v", err))
+		return nil
+	}
+	m.addSystemMessage(fmt.Sprintf("📎 Attached %s, it will be sent with your next message", filepath.Base(path)))
+	return nil
+}
@@ -4,6 +4,7 @@ Copyright © 2023 Kevin.Jayne@iCloud.com
 package main
 
 import (
+	"context"
 	"os"
 	"os/signal"
 	"syscall"
@@ -12,16 +13,19 @@ import (
 )
 
 func main() {
-	// Set up signal handling for Ctrl+C
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	// Cancel the context on Ctrl+C or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 
 	// Handle signals in a goroutine
 	go func() {
-		<-sigChan
+		<-ctx.Done()
+		stop() // Restore default handling so a second signal exits immediately
+		if cmd.GracefulShutdown() {
+			return // The command is winding down on its own
+		}
 		cmd.CleanupAndExit()
 		os.Exit(0)
 	}()
 
-	cmd.Execute()
+	cmd.Execute(ctx)
 }